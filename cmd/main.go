@@ -10,15 +10,20 @@ import (
 	"time"
 
 	"github.com/bugsnag/panicwrap"
+	"github.com/seventv/api/data/events"
+	"github.com/seventv/common/redis"
 	"go.uber.org/zap"
 
 	"github.com/seventv/eventapi/internal/app"
+	"github.com/seventv/eventapi/internal/broker"
 	"github.com/seventv/eventapi/internal/configure"
 	"github.com/seventv/eventapi/internal/global"
 	"github.com/seventv/eventapi/internal/health"
+	"github.com/seventv/eventapi/internal/httpserver"
 	"github.com/seventv/eventapi/internal/monitoring"
 	"github.com/seventv/eventapi/internal/nats"
 	"github.com/seventv/eventapi/internal/pprof"
+	"github.com/seventv/eventapi/internal/tracing"
 )
 
 var (
@@ -59,60 +64,111 @@ func main() {
 	zap.S().Debugf("MaxProcs: ", runtime.GOMAXPROCS(0))
 
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGINT, syscall.SIGHUP, syscall.SIGILL, syscall.SIGTERM, syscall.SIGQUIT)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGILL, syscall.SIGTERM, syscall.SIGQUIT)
 
 	c, cancel := context.WithCancel(context.Background())
 
 	gctx := global.New(c, config)
 
-	{
-		// redis is disabled
-
-		//ctx, cancel := context.WithTimeout(gctx, time.Second*15)
-		//redisInst, err := redis.Setup(ctx, redis.SetupOptions{
-		//	Username:   gctx.Config().Redis.Username,
-		//	Password:   gctx.Config().Redis.Password,
-		//	Database:   gctx.Config().Redis.Database,
-		//	Addresses:  gctx.Config().Redis.Addresses,
-		//	Sentinel:   gctx.Config().Redis.Sentinel,
-		//	MasterName: gctx.Config().Redis.MasterName,
-		//})
-		//cancel()
-		//if err != nil {
-		//	zap.S().Fatalw("failed to connect to redis", "error", err)
-		//}
-
-		//gctx.Inst().Redis = instance.WrapRedis(redisInst)
-		gctx.Inst().Monitoring = monitoring.NewPrometheus(gctx)
-	}
+	gctx.Inst().Monitoring = monitoring.NewPrometheus(gctx)
+	gctx.Inst().Reconnects = app.NewReconnectScheduler(gctx)
+
+	// set up before the broker and the server so that their first spans are exported too
+	flushSpans := tracing.New(gctx)
+
+	switch config.Broker.Kind {
+	case configure.BrokerKindRedis:
+		ctx, cancel := context.WithTimeout(gctx, time.Second*15)
+		redisInst, err := redis.Setup(ctx, redis.SetupOptions{
+			Username:   gctx.Config().Redis.Username,
+			Password:   gctx.Config().Redis.Password,
+			Database:   gctx.Config().Redis.Database,
+			Addresses:  gctx.Config().Redis.Addresses,
+			Sentinel:   gctx.Config().Redis.Sentinel,
+			MasterName: gctx.Config().Redis.MasterName,
+		})
+		cancel()
+		if err != nil {
+			zap.S().Fatalw("failed to connect to redis", "error", err)
+		}
 
-	err = nats.Init(config.Nats.Url, config.Nats.Subject)
-	if err != nil {
-		zap.S().Fatalw("failed to connect to nats", "error", err)
-	}
+		gctx.Inst().Redis = redisInst
+		redisBroker := broker.NewRedis(gctx, gctx.Inst().Redis, config.Redis.Subject)
+		redisBroker.Monitoring = gctx.Inst().Monitoring
+
+		gctx.Inst().Broker = redisBroker
 
-	zap.S().Info("nats, ok")
+		zap.S().Info("redis, ok")
+	case configure.BrokerKindMemory:
+		gctx.Inst().Broker = broker.NewMemory()
+
+		zap.S().Warn("using the in-memory broker, dispatches are not received from other services")
+	default:
+		natsBroker, err := nats.New(gctx)
+		if err != nil {
+			zap.S().Fatalw("failed to connect to nats", "error", err)
+		}
+
+		gctx.Inst().Broker = natsBroker
+
+		zap.S().Info("nats, ok")
+	}
 
 	dones := []<-chan struct{}{}
 
 	var srv *app.Server
 
+	// entrypoints configured with the same bind share a server
+	servers := httpserver.New(gctx)
+
 	if gctx.Config().API.Enabled {
 		var done <-chan struct{}
 
 		srv, done = app.New(gctx)
 		dones = append(dones, done)
+
+		servers.Mount("api", gctx.Config().API.Bind, srv.Routes)
 	}
 	if gctx.Config().PProf.Enabled {
-		dones = append(dones, pprof.New(gctx))
+		servers.Mount("pprof", gctx.Config().PProf.Bind, pprof.Routes(gctx))
 	}
 	if gctx.Config().Health.Enabled {
-		dones = append(dones, health.New(gctx, srv))
+		servers.Mount("health", gctx.Config().Health.Bind, health.Routes(gctx, srv))
 	}
 	if gctx.Config().Monitoring.Enabled {
-		dones = append(dones, monitoring.New(gctx))
+		servers.Mount("monitoring", gctx.Config().Monitoring.Bind, monitoring.Routes(gctx))
 	}
 
+	// the servers stay up until the other subsystems are done, serving readiness while draining
+	dones = append(dones, servers.Serve(dones...))
+
+	// spans are flushed last, once the connections closed by the drain have ended theirs
+	dones = append(dones, flushSpans(dones...))
+
+	// Drain the connections without shutting down, letting the clients move to other servers.
+	// The signal must be sent to the wrapped process, as the panic wrapper does not forward it
+	drainSig := make(chan os.Signal, 1)
+	signal.Notify(drainSig, syscall.SIGUSR1)
+
+	go func() {
+		for range drainSig {
+			if srv == nil {
+				continue
+			}
+
+			if !srv.Drain(app.DefaultDrainOptions(gctx, events.CloseCodeReconnect)) {
+				zap.S().Warn("received a drain signal while already draining")
+			}
+		}
+	}()
+
+	// Reload the settings which can change at runtime when the config file changes or on SIGHUP.
+	// Like SIGUSR1, the signal must be sent to the wrapped process
+	reloadSig := make(chan os.Signal, 1)
+	signal.Notify(reloadSig, syscall.SIGHUP)
+
+	go configure.Watch(gctx, gctx.Config, reloadSig, gctx.SetConfig)
+
 	zap.S().Infof("running")
 
 	done := make(chan struct{})
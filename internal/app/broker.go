@@ -0,0 +1,67 @@
+package app
+
+import (
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/seventv/eventapi/internal/global"
+)
+
+// Outage returns a channel that is closed when the broker stays down beyond the threshold
+func (s *Server) Outage() <-chan struct{} {
+	s.outageMtx.Lock()
+	defer s.outageMtx.Unlock()
+
+	return s.outage
+}
+
+// watchBroker tells clients to reconnect to another server and refuses new connections
+// while the broker is down for longer than the configured threshold
+func (s *Server) watchBroker(gctx global.Context) {
+	threshold := gctx.Config().Broker.DownThreshold
+	if threshold < 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-gctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		status := gctx.Inst().Broker.Status()
+
+		if status.Connected {
+			if atomic.CompareAndSwapInt32(s.brokerDown, 1, 0) {
+				zap.S().Infow("broker recovered, accepting connections")
+			}
+
+			continue
+		}
+
+		if status.DownSince.IsZero() || time.Since(status.DownSince) < time.Duration(threshold)*time.Millisecond {
+			continue
+		}
+
+		if !atomic.CompareAndSwapInt32(s.brokerDown, 0, 1) {
+			continue
+		}
+
+		zap.S().Warnw("broker down beyond threshold, sending clients elsewhere",
+			"down_since", status.DownSince,
+			"error", status.LastError,
+			"connections", atomic.LoadInt32(s.activeConns),
+		)
+
+		s.outageMtx.Lock()
+		close(s.outage)
+		s.outage = make(chan struct{})
+		s.outageMtx.Unlock()
+	}
+}
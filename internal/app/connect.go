@@ -6,10 +6,13 @@ import (
 	"time"
 
 	"github.com/seventv/api/data/events"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 
 	client "github.com/seventv/eventapi/internal/app/connection"
 	"github.com/seventv/eventapi/internal/global"
+	"github.com/seventv/eventapi/internal/httpserver"
 )
 
 func (s *Server) TrackConnection(gctx global.Context, r *http.Request, con client.Connection) {
@@ -21,6 +24,10 @@ func (s *Server) TrackConnection(gctx global.Context, r *http.Request, con clien
 
 	start := time.Now()
 
+	// the span of the connection is started along with it, and ends when the connection is destroyed
+	span := trace.SpanFromContext(con.Context())
+	span.SetAttributes(attribute.String("eventapi.session_id", con.SessionID()))
+
 	// Increment counters
 	atomic.AddInt32(s.activeConns, 1)
 
@@ -44,20 +51,31 @@ func (s *Server) TrackConnection(gctx global.Context, r *http.Request, con clien
 	}
 
 	gctx.Inst().Monitoring.EventV3().CurrentConnections.Inc()
-	gctx.Inst().Monitoring.EventV3().TotalConnections.Observe(1)
+	gctx.Inst().Monitoring.EventV3().Connections.WithLabelValues(string(con.Transport())).Inc()
 
-	clientAddr := r.RemoteAddr
+	clientAddr := httpserver.ClientIP(r)
 
 	zap.S().Debugw("new connection",
 		"client_addr", clientAddr,
 		"connection_count", atomic.LoadInt32(s.activeConns),
 	)
 
-	// Handle shutdown
+	// Handle shutdown, pacing the connections told to reconnect
 	go func() {
 		select {
-		case <-s.shutdown:
-			con.SendClose(events.CloseCodeRestart, 0)
+		case <-s.draining:
+			span.AddEvent("drain")
+
+			s.release(gctx, con)
+		case <-s.Outage():
+			span.AddEvent("broker outage")
+
+			if gctx.Inst().Reconnects.Wait(con.Context()) == nil {
+				_ = con.Write(events.NewMessage(events.OpcodeReconnect, events.ReconnectPayload{
+					Reason: "The server lost its connection to the message broker",
+				}).ToRaw())
+				con.SendClose(events.CloseCodeReconnect, 0)
+			}
 		case <-con.Context().Done():
 			return
 		}
@@ -69,8 +87,7 @@ func (s *Server) TrackConnection(gctx global.Context, r *http.Request, con clien
 	atomic.AddInt32(s.activeConns, -1)
 
 	gctx.Inst().Monitoring.EventV3().CurrentConnections.Dec()
-	gctx.Inst().Monitoring.EventV3().TotalConnections.Observe(float64(time.Since(start)/time.Millisecond) / 1000)
-	gctx.Inst().Monitoring.EventV3().TotalConnectionDurationSeconds.Observe(float64(time.Since(start).Seconds()))
+	gctx.Inst().Monitoring.EventV3().ConnectionDuration.WithLabelValues(string(con.Transport())).Observe(time.Since(start).Seconds())
 
 	zap.S().Debugw("connection ended",
 		"client_addr", clientAddr,
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"github.com/seventv/api/data/events"
+	"github.com/seventv/common/utils"
+	"go.uber.org/zap"
+
+	"github.com/seventv/eventapi/internal/global"
+)
+
+// SubscriptionResult is the outcome of an item of a batched subscription command
+type SubscriptionResult struct {
+	ID        uint32           `json:"id,omitempty"`
+	Type      events.EventType `json:"type"`
+	Condition Condition        `json:"condition"`
+	Error     *Error           `json:"error,omitempty"`
+}
+
+// isBatch checks whether the payload of a command is a list of items
+func isBatch(data json.RawMessage) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte{'['})
+}
+
+// invalidPayloadError reports a subscription command which couldn't be decoded
+func invalidPayloadError(err error) *Error {
+	return NewError(ErrorCodeInvalidPayload, map[string]any{
+		"error": err.Error(),
+	})
+}
+
+// decodeBatch decodes the items of a batched command, rejecting it if the list itself is invalid
+func (h handler) decodeBatch(m ClientMessage) ([]json.RawMessage, bool) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(m.Data, &items); err != nil {
+		h.reject(m, invalidPayloadError(err))
+
+		return nil, false
+	}
+
+	if len(items) > SUBSCRIPTION_BATCH_MAX {
+		h.reject(m, NewError(ErrorCodeBatchTooLarge, map[string]any{
+			"items":      len(items),
+			"items_most": SUBSCRIPTION_BATCH_MAX,
+		}))
+
+		return nil, false
+	}
+
+	return items, true
+}
+
+// subscribeBatch adds each subscription of a batch independently,
+// acknowledging the command with the result of every item
+func (h handler) subscribeBatch(gctx global.Context, m ClientMessage) (error, bool) {
+	items, ok := h.decodeBatch(m)
+	if !ok {
+		return nil, false
+	}
+
+	results := make([]SubscriptionResult, len(items))
+
+	for i, item := range items {
+		var payload SubscribePayload
+		if err := json.Unmarshal(item, &payload); err != nil {
+			results[i].Error = invalidPayloadError(err)
+
+			continue
+		}
+
+		results[i].Type = payload.Type
+		results[i].Condition = payload.Condition
+
+		id, serr, err := h.subscribe(gctx, payload)
+		if err != nil {
+			zap.S().Errorw("failed to subscribe batch item",
+				"error", err,
+			)
+
+			serr = NewError(ErrorCodeSubscriptionFailed, nil)
+		}
+
+		results[i].ID = id
+		results[i].Error = serr
+	}
+
+	_ = h.conn.SendAck(events.OpcodeSubscribe, utils.ToJSON(struct {
+		Results []SubscriptionResult `json:"results"`
+	}{
+		Results: results,
+	}), m.Nonce)
+
+	return nil, true
+}
+
+// unsubscribeBatch removes each subscription of a batch independently,
+// acknowledging the command with the result of every item
+func (h handler) unsubscribeBatch(gctx global.Context, m ClientMessage) error {
+	items, ok := h.decodeBatch(m)
+	if !ok {
+		return nil
+	}
+
+	results := make([]SubscriptionResult, len(items))
+
+	for i, item := range items {
+		var payload UnsubscribePayload
+		if err := json.Unmarshal(item, &payload); err != nil {
+			results[i].Error = invalidPayloadError(err)
+
+			continue
+		}
+
+		results[i].Type = payload.Type
+		results[i].Condition = payload.Condition
+
+		id, err := h.unsubscribe(gctx, payload)
+
+		switch {
+		case err == nil:
+			results[i].ID = id
+		case errors.Is(err, ErrNotSubscribed):
+			results[i].Error = NewError(ErrorCodeNotSubscribed, nil)
+		default:
+			zap.S().Errorw("failed to unsubscribe batch item",
+				"error", err,
+			)
+
+			results[i].Error = NewError(ErrorCodeSubscriptionFailed, nil)
+		}
+	}
+
+	_ = h.conn.SendAck(events.OpcodeUnsubscribe, utils.ToJSON(struct {
+		Results []SubscriptionResult `json:"results"`
+	}{
+		Results: results,
+	}), m.Nonce)
+
+	return nil
+}
@@ -0,0 +1,375 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/seventv/api/data/events"
+
+	"github.com/seventv/eventapi/internal/instance"
+)
+
+// Condition filters the dispatches of a subscription, mapping the keys of
+// the dispatch conditions to the values they accept
+//
+// In JSON, a value is either a string, matched exactly, a list of strings matching any of them,
+// or an object with one of the operators "eq", "in", "prefix" or "not", the latter negating another value
+type Condition map[string]ConditionValue
+
+type ConditionOperator string
+
+const (
+	ConditionOperatorEqual  ConditionOperator = "eq"
+	ConditionOperatorIn     ConditionOperator = "in"
+	ConditionOperatorPrefix ConditionOperator = "prefix"
+)
+
+type ConditionValue struct {
+	Op     ConditionOperator
+	Values []string
+	Negate bool
+}
+
+// SubscribePayload is the payload of a SUBSCRIBE command
+type SubscribePayload struct {
+	// The ID requested for the subscription, generated if omitted
+	ID        uint32           `json:"id,omitempty"`
+	Type      events.EventType `json:"type"`
+	Condition Condition        `json:"condition"`
+	TTL       time.Duration    `json:"ttl,omitempty"`
+}
+
+// UnsubscribePayload is the payload of an UNSUBSCRIBE command
+type UnsubscribePayload struct {
+	// The ID of the subscription to remove, in place of its type and condition
+	ID        uint32           `json:"id,omitempty"`
+	Type      events.EventType `json:"type"`
+	Condition Condition        `json:"condition"`
+}
+
+// NewCondition creates a condition matching the values of an event condition exactly
+func NewCondition(cond events.EventCondition) Condition {
+	c := make(Condition, len(cond))
+	for k, v := range cond {
+		c[k] = ConditionValue{
+			Op:     ConditionOperatorEqual,
+			Values: []string{v},
+		}
+	}
+
+	return c
+}
+
+// ParseCondition parses the inline syntax of a condition, a list of ";" separated items
+// formatted as "key=value", "key=value1|value2" or "key^=prefix", each of which can be negated with "!", as in "key!=value"
+func ParseCondition(s string) (Condition, error) {
+	c := Condition{}
+
+	for _, item := range strings.Split(s, ";") {
+		if item == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(item, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("bad condition %q", item)
+		}
+
+		v := ConditionValue{
+			Op:     ConditionOperatorEqual,
+			Values: strings.Split(value, "|"),
+		}
+
+		if strings.HasSuffix(key, "^") {
+			key = strings.TrimSuffix(key, "^")
+			v.Op = ConditionOperatorPrefix
+		} else if len(v.Values) > 1 {
+			v.Op = ConditionOperatorIn
+		}
+
+		if strings.HasSuffix(key, "!") {
+			key = strings.TrimSuffix(key, "!")
+			v.Negate = true
+		}
+
+		// operators in another order, such as "key^!=", are left in the key
+		if key == "" || strings.ContainsAny(key, "!^") {
+			return nil, fmt.Errorf("bad condition %q", item)
+		}
+
+		c[key] = v
+	}
+
+	return c, nil
+}
+
+// Accepts checks whether the condition accepts each of the key/value pairs of a dispatch condition
+func (c Condition) Accepts(cond events.EventCondition) bool {
+	for k, v := range cond {
+		cv, ok := c[k]
+		if !ok {
+			if v != "" {
+				return false
+			}
+
+			continue
+		}
+
+		if !cv.Accepts(v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Includes checks whether the condition has each of the keys of another with the same value
+func (c Condition) Includes(other Condition) bool {
+	for k, v := range other {
+		if cv, ok := c[k]; !ok || !cv.Equal(v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Wildcard checks whether the condition doesn't target specific values,
+// having no key which accepts either listed values or a non-empty prefix
+func (c Condition) Wildcard() bool {
+	for _, v := range c {
+		if v.Negate {
+			continue
+		}
+
+		if v.Op != ConditionOperatorPrefix || !v.Accepts("") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ValueCount returns the total number of values in the condition
+func (c Condition) ValueCount() int {
+	n := 0
+	for _, v := range c {
+		n += len(v.Values)
+	}
+
+	return n
+}
+
+// Routable checks whether the dispatch keys matching the condition can be enumerated,
+// which requires it to only accept specific values
+func (c Condition) Routable() bool {
+	for _, v := range c {
+		if !v.Indexable() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// DispatchKeys returns the keys of the dispatches which the condition may match
+//
+// Each combination of accepted values has its own key, up to the specified limit
+// beyond which, as well as for conditions which aren't routable, the wildcard key of the event type is used
+func (c Condition) DispatchKeys(t events.EventType, limit int) []string {
+	n := 1
+	for _, v := range c {
+		n *= len(v.Values)
+	}
+
+	if !c.Routable() || n > limit {
+		return []string{string(t) + instance.KeyWildcard}
+	}
+
+	// iterate in a stable order so that the keys are consistent
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	combos := []events.EventCondition{{}}
+
+	for _, k := range keys {
+		next := make([]events.EventCondition, 0, len(combos)*len(c[k].Values))
+
+		for _, combo := range combos {
+			for _, v := range c[k].Values {
+				ec := make(events.EventCondition, len(combo)+1)
+				for ck, cv := range combo {
+					ec[ck] = cv
+				}
+
+				ec[k] = v
+				next = append(next, ec)
+			}
+		}
+
+		combos = next
+	}
+
+	result := make([]string, len(combos))
+	for i, ec := range combos {
+		result[i] = events.CreateDispatchKey(t, ec, false)
+	}
+
+	return result
+}
+
+// Accepts checks whether a value satisfies the operator
+func (v ConditionValue) Accepts(value string) bool {
+	ok := false
+
+	for _, s := range v.Values {
+		if s == value || (v.Op == ConditionOperatorPrefix && strings.HasPrefix(value, s)) {
+			ok = true
+			break
+		}
+	}
+
+	return ok != v.Negate
+}
+
+// Indexable checks whether the value only accepts the values it lists
+func (v ConditionValue) Indexable() bool {
+	return !v.Negate && v.Op != ConditionOperatorPrefix
+}
+
+func (v ConditionValue) Equal(other ConditionValue) bool {
+	if v.Op != other.Op || v.Negate != other.Negate || len(v.Values) != len(other.Values) {
+		return false
+	}
+
+	for i := range v.Values {
+		if v.Values[i] != other.Values[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+type conditionObject struct {
+	Eq     *string          `json:"eq,omitempty"`
+	In     []string         `json:"in,omitempty"`
+	Prefix conditionValues  `json:"prefix,omitempty"`
+	Not    *json.RawMessage `json:"not,omitempty"`
+}
+
+// conditionValues is a list of values which may be written as a single string
+type conditionValues []string
+
+func (v *conditionValues) UnmarshalJSON(b []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte{'"'}) {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+
+		*v = conditionValues{s}
+
+		return nil
+	}
+
+	return json.Unmarshal(b, (*[]string)(v))
+}
+
+func (v conditionValues) MarshalJSON() ([]byte, error) {
+	if len(v) == 1 {
+		return json.Marshal(v[0])
+	}
+
+	return json.Marshal([]string(v))
+}
+
+func (v *ConditionValue) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return fmt.Errorf("empty condition value")
+	}
+
+	switch b[0] {
+	case '"':
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+
+		*v = ConditionValue{Op: ConditionOperatorEqual, Values: []string{s}}
+	case '[':
+		var s []string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+
+		*v = ConditionValue{Op: ConditionOperatorIn, Values: s}
+	case '{':
+		var o conditionObject
+		if err := json.Unmarshal(b, &o); err != nil {
+			return err
+		}
+
+		switch {
+		case o.Eq != nil:
+			*v = ConditionValue{Op: ConditionOperatorEqual, Values: []string{*o.Eq}}
+		case o.In != nil:
+			*v = ConditionValue{Op: ConditionOperatorIn, Values: o.In}
+		case o.Prefix != nil:
+			*v = ConditionValue{Op: ConditionOperatorPrefix, Values: o.Prefix}
+		case o.Not != nil:
+			if err := v.UnmarshalJSON(*o.Not); err != nil {
+				return err
+			}
+
+			v.Negate = !v.Negate
+		default:
+			return fmt.Errorf("unknown condition operator")
+		}
+	default:
+		return fmt.Errorf("bad condition value")
+	}
+
+	if len(v.Values) == 0 {
+		return fmt.Errorf("empty condition value")
+	}
+
+	if v.Op == ConditionOperatorIn && len(v.Values) == 1 {
+		v.Op = ConditionOperatorEqual
+	}
+
+	return nil
+}
+
+func (v ConditionValue) MarshalJSON() ([]byte, error) {
+	var r any
+
+	switch {
+	case v.Op == ConditionOperatorEqual && len(v.Values) == 1:
+		r = v.Values[0]
+	case v.Op == ConditionOperatorPrefix:
+		r = conditionObject{Prefix: v.Values}
+	default:
+		r = v.Values
+	}
+
+	if v.Negate {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return nil, err
+		}
+
+		r = conditionObject{Not: (*json.RawMessage)(&b)}
+	}
+
+	return json.Marshal(r)
+}
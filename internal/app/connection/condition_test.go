@@ -0,0 +1,338 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/seventv/api/data/events"
+	"github.com/seventv/common/utils"
+
+	"github.com/seventv/eventapi/internal/instance"
+)
+
+func TestConditionAccepts(t *testing.T) {
+	eq := func(v string) ConditionValue {
+		return ConditionValue{Op: ConditionOperatorEqual, Values: []string{v}}
+	}
+
+	tests := []struct {
+		name     string
+		cond     Condition
+		dispatch events.EventCondition
+		want     bool
+	}{
+		{"eq", Condition{"object_id": eq("1")}, events.EventCondition{"object_id": "1"}, true},
+		{"eq mismatch", Condition{"object_id": eq("1")}, events.EventCondition{"object_id": "2"}, false},
+		{"eq empty dispatch value", Condition{"object_id": eq("1")}, events.EventCondition{"object_id": ""}, false},
+		{"eq empty value", Condition{"object_id": eq("")}, events.EventCondition{"object_id": ""}, true},
+		{"eq key not dispatched", Condition{"object_id": eq("1")}, events.EventCondition{"host_id": "1"}, false},
+		{"eq key not dispatched, empty value", Condition{"object_id": eq("1")}, events.EventCondition{"host_id": ""}, true},
+		{"eq several keys", Condition{"object_id": eq("1"), "host_id": eq("2")}, events.EventCondition{"object_id": "1", "host_id": "2"}, true},
+		{"eq one of several keys", Condition{"object_id": eq("1"), "host_id": eq("2")}, events.EventCondition{"object_id": "1"}, true},
+		{"eq one of several keys mismatch", Condition{"object_id": eq("1"), "host_id": eq("2")}, events.EventCondition{"object_id": "1", "host_id": "3"}, false},
+		{"no condition, empty dispatch", Condition{}, events.EventCondition{}, true},
+		{"no condition", Condition{}, events.EventCondition{"object_id": "1"}, false},
+		{"empty dispatch", Condition{"object_id": eq("1")}, events.EventCondition{}, true},
+
+		{"in", Condition{"object_id": {Op: ConditionOperatorIn, Values: []string{"1", "2"}}}, events.EventCondition{"object_id": "2"}, true},
+		{"in mismatch", Condition{"object_id": {Op: ConditionOperatorIn, Values: []string{"1", "2"}}}, events.EventCondition{"object_id": "3"}, false},
+		{"in empty dispatch value", Condition{"object_id": {Op: ConditionOperatorIn, Values: []string{"1", "2"}}}, events.EventCondition{"object_id": ""}, false},
+
+		{"prefix", Condition{"object_id": {Op: ConditionOperatorPrefix, Values: []string{"ab"}}}, events.EventCondition{"object_id": "abc"}, true},
+		{"prefix exact", Condition{"object_id": {Op: ConditionOperatorPrefix, Values: []string{"ab"}}}, events.EventCondition{"object_id": "ab"}, true},
+		{"prefix mismatch", Condition{"object_id": {Op: ConditionOperatorPrefix, Values: []string{"ab"}}}, events.EventCondition{"object_id": "ba"}, false},
+		{"prefix empty dispatch value", Condition{"object_id": {Op: ConditionOperatorPrefix, Values: []string{"ab"}}}, events.EventCondition{"object_id": ""}, false},
+		{"empty prefix empty dispatch value", Condition{"object_id": {Op: ConditionOperatorPrefix, Values: []string{""}}}, events.EventCondition{"object_id": ""}, true},
+
+		{"not", Condition{"object_id": {Op: ConditionOperatorEqual, Values: []string{"1"}, Negate: true}}, events.EventCondition{"object_id": "2"}, true},
+		{"not mismatch", Condition{"object_id": {Op: ConditionOperatorEqual, Values: []string{"1"}, Negate: true}}, events.EventCondition{"object_id": "1"}, false},
+		{"not empty dispatch value", Condition{"object_id": {Op: ConditionOperatorEqual, Values: []string{"1"}, Negate: true}}, events.EventCondition{"object_id": ""}, true},
+		{"not in", Condition{"object_id": {Op: ConditionOperatorIn, Values: []string{"1", "2"}, Negate: true}}, events.EventCondition{"object_id": "2"}, false},
+		{"not prefix", Condition{"object_id": {Op: ConditionOperatorPrefix, Values: []string{"ab"}, Negate: true}}, events.EventCondition{"object_id": "abc"}, false},
+		{"not prefix mismatch", Condition{"object_id": {Op: ConditionOperatorPrefix, Values: []string{"ab"}, Negate: true}}, events.EventCondition{"object_id": "xyz"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cond.Accepts(tt.dispatch); got != tt.want {
+				t.Errorf("Accepts(%v) = %t, want %t", tt.dispatch, got, tt.want)
+			}
+
+			// conditions of exact values must keep the semantics of the EventCondition they replaced
+			if old, ok := eventCondition(tt.cond); ok {
+				if got := old.Match(tt.dispatch); got != tt.want {
+					t.Errorf("EventCondition.Match(%v) = %t, Accepts disagrees", tt.dispatch, got)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCondition(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Condition
+	}{
+		{"", Condition{}},
+		{"object_id=1", Condition{"object_id": {Op: ConditionOperatorEqual, Values: []string{"1"}}}},
+		{"object_id=", Condition{"object_id": {Op: ConditionOperatorEqual, Values: []string{""}}}},
+		{"object_id=1|2", Condition{"object_id": {Op: ConditionOperatorIn, Values: []string{"1", "2"}}}},
+		{"object_id^=ab", Condition{"object_id": {Op: ConditionOperatorPrefix, Values: []string{"ab"}}}},
+		{"object_id^=ab|cd", Condition{"object_id": {Op: ConditionOperatorPrefix, Values: []string{"ab", "cd"}}}},
+		{"object_id!=1", Condition{"object_id": {Op: ConditionOperatorEqual, Values: []string{"1"}, Negate: true}}},
+		{"object_id!=1|2", Condition{"object_id": {Op: ConditionOperatorIn, Values: []string{"1", "2"}, Negate: true}}},
+		{"object_id!^=ab", Condition{"object_id": {Op: ConditionOperatorPrefix, Values: []string{"ab"}, Negate: true}}},
+		{"object_id=1;host_id^=2;", Condition{
+			"object_id": {Op: ConditionOperatorEqual, Values: []string{"1"}},
+			"host_id":   {Op: ConditionOperatorPrefix, Values: []string{"2"}},
+		}},
+		{"object_id=a=b", Condition{"object_id": {Op: ConditionOperatorEqual, Values: []string{"a=b"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseCondition(tt.in)
+			if err != nil {
+				t.Fatalf("ParseCondition(%q) = %v", tt.in, err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseCondition(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+
+	for _, in := range []string{
+		"object_id",
+		"=1",
+		"!=1",
+		"^=1",
+		"!^=1",
+		// the negation comes before the prefix operator, as in "key!^="
+		"object_id^!=1",
+		"object_id!!=1",
+		"object_id=1;host_id",
+	} {
+		if c, err := ParseCondition(in); err == nil {
+			t.Errorf("ParseCondition(%q) = %v, want an error", in, c)
+		}
+	}
+}
+
+func TestConditionJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want ConditionValue
+		out  string // the canonical encoding, in if empty
+	}{
+		{`"1"`, ConditionValue{Op: ConditionOperatorEqual, Values: []string{"1"}}, ""},
+		{`["1","2"]`, ConditionValue{Op: ConditionOperatorIn, Values: []string{"1", "2"}}, ""},
+		{`["1"]`, ConditionValue{Op: ConditionOperatorEqual, Values: []string{"1"}}, `"1"`},
+		{`{"eq":"1"}`, ConditionValue{Op: ConditionOperatorEqual, Values: []string{"1"}}, `"1"`},
+		{`{"in":["1","2"]}`, ConditionValue{Op: ConditionOperatorIn, Values: []string{"1", "2"}}, `["1","2"]`},
+		{`{"prefix":"ab"}`, ConditionValue{Op: ConditionOperatorPrefix, Values: []string{"ab"}}, ""},
+		{`{"prefix":["ab","cd"]}`, ConditionValue{Op: ConditionOperatorPrefix, Values: []string{"ab", "cd"}}, ""},
+		{`{"not":"1"}`, ConditionValue{Op: ConditionOperatorEqual, Values: []string{"1"}, Negate: true}, ""},
+		{`{"not":["1","2"]}`, ConditionValue{Op: ConditionOperatorIn, Values: []string{"1", "2"}, Negate: true}, ""},
+		{`{"not":{"prefix":"ab"}}`, ConditionValue{Op: ConditionOperatorPrefix, Values: []string{"ab"}, Negate: true}, ""},
+		{`{"not":{"not":"1"}}`, ConditionValue{Op: ConditionOperatorEqual, Values: []string{"1"}}, `"1"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			var v ConditionValue
+			if err := json.Unmarshal([]byte(tt.in), &v); err != nil {
+				t.Fatalf("Unmarshal(%s) = %v", tt.in, err)
+			}
+
+			if !v.Equal(tt.want) {
+				t.Fatalf("Unmarshal(%s) = %+v, want %+v", tt.in, v, tt.want)
+			}
+
+			b, err := json.Marshal(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if out := utils.Ternary(tt.out == "", tt.in, tt.out); string(b) != out {
+				t.Errorf("Marshal() = %s, want %s", b, out)
+			}
+
+			var again ConditionValue
+			if err := json.Unmarshal(b, &again); err != nil || !again.Equal(v) {
+				t.Errorf("Unmarshal(%s) = %+v, %v, the round-trip changed the value", b, again, err)
+			}
+		})
+	}
+
+	for _, in := range []string{``, `1`, `[]`, `{}`, `{"in":[]}`, `{"gt":"1"}`, `{"not":[]}`, `null`} {
+		var v ConditionValue
+		if err := json.Unmarshal([]byte(in), &v); err == nil {
+			t.Errorf("Unmarshal(%s) = %+v, want an error", in, v)
+		}
+	}
+
+	// a whole condition round-trips through the subscribe payload
+	var p SubscribePayload
+	if err := json.Unmarshal([]byte(`{"type":"emote_set.update","condition":{"object_id":["1","2"],"host_id":{"not":{"prefix":"x"}}}}`), &p); err != nil {
+		t.Fatal(err)
+	}
+
+	var again SubscribePayload
+	if err := json.Unmarshal(utils.ToJSON(p), &again); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(p.Condition, again.Condition) {
+		t.Errorf("condition %v became %v through JSON", p.Condition, again.Condition)
+	}
+}
+
+func TestConditionDispatchKeys(t *testing.T) {
+	const et = events.EventType("emote_set.update")
+
+	wildcard := []string{string(et) + instance.KeyWildcard}
+
+	keys := func(conds ...events.EventCondition) []string {
+		r := make([]string, len(conds))
+		for i, ec := range conds {
+			r[i] = events.CreateDispatchKey(et, ec, false)
+		}
+
+		return r
+	}
+
+	tests := []struct {
+		name  string
+		cond  Condition
+		limit int
+		want  []string
+	}{
+		{"no condition", Condition{}, 10, keys(events.EventCondition{})},
+		{"eq", NewCondition(events.EventCondition{"object_id": "1"}), 10, keys(events.EventCondition{"object_id": "1"})},
+		{"in", Condition{"object_id": {Op: ConditionOperatorIn, Values: []string{"1", "2"}}}, 10, keys(
+			events.EventCondition{"object_id": "1"},
+			events.EventCondition{"object_id": "2"},
+		)},
+		// every combination, keys in sorted order
+		{"several keys", Condition{
+			"object_id": {Op: ConditionOperatorIn, Values: []string{"1", "2"}},
+			"host_id":   {Op: ConditionOperatorEqual, Values: []string{"a"}},
+		}, 10, keys(
+			events.EventCondition{"host_id": "a", "object_id": "1"},
+			events.EventCondition{"host_id": "a", "object_id": "2"},
+		)},
+		{"at the limit", Condition{"object_id": {Op: ConditionOperatorIn, Values: []string{"1", "2"}}}, 2, keys(
+			events.EventCondition{"object_id": "1"},
+			events.EventCondition{"object_id": "2"},
+		)},
+		{"beyond the limit", Condition{
+			"object_id": {Op: ConditionOperatorIn, Values: []string{"1", "2"}},
+			"host_id":   {Op: ConditionOperatorIn, Values: []string{"a", "b"}},
+		}, 3, wildcard},
+		{"prefix", Condition{"object_id": {Op: ConditionOperatorPrefix, Values: []string{"ab"}}}, 10, wildcard},
+		{"negated", Condition{"object_id": {Op: ConditionOperatorEqual, Values: []string{"1"}, Negate: true}}, 10, wildcard},
+		{"one key not routable", Condition{
+			"object_id": {Op: ConditionOperatorEqual, Values: []string{"1"}},
+			"host_id":   {Op: ConditionOperatorPrefix, Values: []string{"a"}},
+		}, 10, wildcard},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cond.DispatchKeys(et, tt.limit); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DispatchKeys() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// eventCondition converts a condition of exact values to the EventCondition it replaced
+func eventCondition(c Condition) (events.EventCondition, bool) {
+	ec := events.EventCondition{}
+
+	for k, v := range c {
+		if v.Op != ConditionOperatorEqual || v.Negate || len(v.Values) != 1 {
+			return nil, false
+		}
+
+		ec[k] = v.Values[0]
+	}
+
+	return ec, true
+}
+
+// linearScan matches subscriptions the way connections did before they were indexed,
+// checking the dispatch conditions against every subscription of the type
+func linearScan(ids []uint32, conds []events.EventCondition, subs []events.EventCondition) []uint32 {
+	if len(subs) == 0 {
+		return ids
+	}
+
+	matches := make(utils.Set[uint32], 0)
+
+	for _, c := range conds {
+		for i, e := range subs {
+			if e.Match(c) {
+				matches.Add(ids[i])
+			}
+		}
+	}
+
+	return matches.Values()
+}
+
+// BenchmarkEventMapDispatch resolves the subscriptions matched by a dispatch
+// among many subscriptions to the same type, each with its own condition
+func BenchmarkEventMapDispatch(b *testing.B) {
+	const t = events.EventType("emote_set.update")
+
+	for _, n := range []int{10, 100, 1000} {
+		ids := make([]uint32, n)
+		subs := make([]events.EventCondition, n)
+
+		for i := range subs {
+			ids[i] = uint32(i + 1)
+			subs[i] = events.EventCondition{"object_id": fmt.Sprint(i), "host_id": fmt.Sprint(i % 10)}
+		}
+
+		// a dispatch carries several conditions, one of which is subscribed to
+		conds := []events.EventCondition{
+			{"object_id": fmt.Sprint(n / 2), "host_id": fmt.Sprint(n / 2 % 10)},
+			{"object_id": "unknown", "host_id": "unknown"},
+			{"object_id": fmt.Sprint(n + 1), "host_id": "0"},
+		}
+
+		b.Run(fmt.Sprintf("linear_scan/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if m := linearScan(ids, conds, subs); len(m) != 1 {
+					b.Fatalf("matched %d subscriptions, want 1", len(m))
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("index/%d", n), func(b *testing.B) {
+			gctx, e := newTestEventMap(b)
+			defer e.Destroy(gctx)
+
+			for i, c := range subs {
+				if _, _, err := e.Subscribe(gctx, context.Background(), t, NewCondition(c), EventSubscriptionProperties{ID: ids[i]}); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			var dst []uint32
+
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if dst = e.Match(t, conds, dst[:0]); len(dst) != 1 {
+					b.Fatalf("matched %d subscriptions, want 1", len(dst))
+				}
+			}
+		})
+	}
+}
@@ -8,14 +8,16 @@ import (
 	"math/rand"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/seventv/api/data/events"
 	"github.com/seventv/common/structures/v3"
 	"github.com/seventv/common/utils"
 
 	"github.com/seventv/eventapi/internal/global"
-	"github.com/seventv/eventapi/internal/nats"
+	"github.com/seventv/eventapi/internal/instance"
 )
 
 type Connection interface {
@@ -28,12 +30,16 @@ type Connection interface {
 	Read(gctx global.Context)
 	// SendHeartbeat lets the client know that the connection is healthy
 	SendHeartbeat() error
-	// SendAck sends an Ack message to the client
-	SendAck(cmd events.Opcode, data json.RawMessage) error
+	// SendAck sends an Ack message to the client, echoing the nonce of the command
+	SendAck(cmd events.Opcode, data json.RawMessage, nonce string) error
 	// SendError publishes an error message to the client
-	SendError(txt string, fields map[string]any)
+	SendError(e *Error)
 	// Write sends a message to the client
 	Write(msg events.Message[json.RawMessage]) error
+	// WriteDispatch sends a dispatch to the client with the IDs of the subscriptions it matched
+	WriteDispatch(msg *instance.BrokerMessage, matches []uint32) error
+	// ReplayHistory sends the retained messages written after the specified sequence again
+	ReplayHistory(after uint64) (HistoryResult, error)
 	// Actor returns the authenticated user for this connection
 	Actor() *structures.User
 	// Handler returns a utility to handle commands for the connection
@@ -54,6 +60,48 @@ type Connection interface {
 	SetWriter(w *bufio.Writer, f http.Flusher)
 	// Return the name of the transport used by this connection
 	Transport() Transport
+	// Mode returns the protocol mode negotiated by the client
+	Mode() ProtocolMode
+}
+
+// ClientMessage is a command sent by the client
+//
+// The client may identify the command with a nonce, which is echoed in the ACK or ERROR it results in
+type ClientMessage struct {
+	events.Message[json.RawMessage]
+	Nonce string `json:"nonce,omitempty"`
+
+	ctx context.Context
+}
+
+// Context returns the context the command is handled in, carrying its span
+func (m ClientMessage) Context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+
+	return m.ctx
+}
+
+// WithContext returns a copy of the command handled in the specified context
+func (m ClientMessage) WithContext(ctx context.Context) ClientMessage {
+	m.ctx = ctx
+
+	return m
+}
+
+// ReconnectPayload is the payload of a RECONNECT message
+type ReconnectPayload struct {
+	Reason string `json:"reason"`
+	// A less loaded server the client may reconnect to
+	Hint string `json:"hint,omitempty"`
+}
+
+// AckPayload is the payload of an ACK message
+type AckPayload struct {
+	Command string          `json:"command"`
+	Data    json.RawMessage `json:"data"`
+	Nonce   string          `json:"nonce,omitempty"`
 }
 
 func IsClientSentOp(op events.Opcode) bool {
@@ -64,7 +112,9 @@ func IsClientSentOp(op events.Opcode) bool {
 		events.OpcodeSubscribe,
 		events.OpcodeUnsubscribe,
 		events.OpcodeSignal,
-		events.OpcodeBridge:
+		events.OpcodeBridge,
+		OpcodeListSubscriptions,
+		OpcodeReplay:
 		return true
 	default:
 		return false
@@ -81,35 +131,83 @@ func GenerateSessionID(n int) ([]byte, error) {
 	return b, nil
 }
 
-func NewEventMap(sessionID string) *EventMap {
-	return &EventMap{
-		subscription: nats.NewSub(sessionID),
+func NewEventMap(gctx global.Context, sessionID string) *EventMap {
+	e := &EventMap{
+		subscription: gctx.Inst().Broker.NewSubscription(sessionID),
+		sessionID:    sessionID,
+		metric:       gctx.Inst().Monitoring.EventV3().Subscriptions,
 		count:        utils.PointerOf(int32(0)),
+		watch:        utils.PointerOf(int32(0)),
 		m:            map[events.EventType]EventChannel{},
+		index:        newSubscriptionIndex(),
+		ids:          map[uint32]events.EventType{},
+		keys:         map[string]int{},
 		mx:           sync.Mutex{},
 	}
+
+	e.expiry = newExpiryScheduler(e.expire)
+
+	return e
+}
+
+// metricObjects are the objects labelled by name in the subscription metrics.
+// the types requested by clients are arbitrary, so any other object is counted as "other"
+var metricObjects = map[string]bool{
+	"system":      true,
+	"emote":       true,
+	"emote_set":   true,
+	"user":        true,
+	"cosmetic":    true,
+	"entitlement": true,
+	"whisper":     true,
+}
+
+// metricObject returns the label of the subscriptions to an event type
+func metricObject(t events.EventType) string {
+	if o := t.ObjectName(); metricObjects[o] {
+		return o
+	}
+
+	return "other"
 }
 
 type EventMap struct {
-	subscription *nats.Subscription
+	subscription instance.BrokerSubscription
+	sessionID    string
 	count        *int32
+	watch        *int32 // whether the client is notified of the subscriptions changed on its behalf
 	m            map[events.EventType]EventChannel
+	index        *subscriptionIndex
+	ids          map[uint32]events.EventType // the type of each subscription
+	keys         map[string]int              // dispatch keys, with the number of subscriptions using them
 	mx           sync.Mutex
 	once         sync.Once
+	expiry       *expiryScheduler
+	metric       *prometheus.GaugeVec // subscriptions by object
 }
 
 // Subscribe sets up a subscription to dispatch events with the specified type
+//
+// If the properties specify a TTL, the subscription is removed once it passes.
+// If they specify an ID, it must not be used by another subscription of the connection
 func (e *EventMap) Subscribe(
 	gctx global.Context,
 	ctx context.Context,
 	t events.EventType,
-	cond events.EventCondition,
+	cond Condition,
 	props EventSubscriptionProperties,
 ) (EventChannel, uint32, error) {
 	e.mx.Lock()
 	defer e.mx.Unlock()
 
-	id := rand.Uint32()
+	// generate an unused ID unless one was requested
+	id := props.ID
+	for id == 0 {
+		id = rand.Uint32()
+		if _, taken := e.ids[id]; taken {
+			id = 0
+		}
+	}
 
 	ec, exists := e.m[t]
 	if !exists {
@@ -119,7 +217,7 @@ func (e *EventMap) Subscribe(
 			ctx:        lctx,
 			cancel:     cancel,
 			ID:         []uint32{},
-			Conditions: []events.EventCondition{},
+			Conditions: []Condition{},
 			Properties: []EventSubscriptionProperties{},
 		}
 	}
@@ -129,143 +227,247 @@ func (e *EventMap) Subscribe(
 	}
 
 	for i, c := range ec.Conditions {
-		if c.Match(cond) {
+		if c.Includes(cond) {
 			if ec.Properties[i].Auto {
-				return ec, id, nil
+				// extend the lifetime of a temporary auto subscription
+				if !ec.Properties[i].TTL.IsZero() && (props.TTL.IsZero() || props.TTL.After(ec.Properties[i].TTL)) {
+					ec.Properties[i].TTL = props.TTL
+
+					if !props.TTL.IsZero() {
+						e.expiry.Schedule(ec.ID[i], props.TTL)
+					}
+				}
+
+				return ec, ec.ID[i], nil
 			}
 
 			return ec, id, ErrAlreadySubscribed
 		}
 	}
 
+	if _, taken := e.ids[id]; taken {
+		return ec, id, ErrSubscriptionIDTaken
+	}
+
 	ec.ID = append(ec.ID, id)
 	ec.Conditions = append(ec.Conditions, cond)
 	ec.Properties = append(ec.Properties, props)
 
 	// Create channel
 	e.m[t] = ec
+	e.index.add(t, id, cond)
+	e.ids[id] = t
+	e.metric.WithLabelValues(metricObject(t)).Inc()
+
+	// subscriptions made on the client's behalf don't count towards its limit
+	if !props.Auto {
+		atomic.AddInt32(e.count, 1)
+	}
+	e.addKeys(cond.DispatchKeys(t, SUBSCRIPTION_CONDITION_VALUES_MAX))
 
-	e.subscription.Subscribe(events.CreateDispatchKey(t, cond, false))
+	if !props.TTL.IsZero() {
+		e.expiry.Schedule(id, props.TTL)
+	}
 
 	return ec, id, nil
 }
 
-func (e *EventMap) Unsubscribe(gctx global.Context, t events.EventType, cond map[string]string) (uint32, error) {
+func (e *EventMap) Unsubscribe(gctx global.Context, t events.EventType, cond Condition) (uint32, error) {
 	e.mx.Lock()
 	defer e.mx.Unlock()
 
+	ec, exists := e.m[t]
+	if !exists {
+		return 0, ErrNotSubscribed
+	}
+
+	// No condition: remove the whole event type
 	if len(cond) == 0 {
-		_, exists := e.m[t]
-		delete(e.m, t)
-		if !exists {
-			return 0, ErrNotSubscribed
+		for i, c := range ec.Conditions {
+			e.removeKeys(c.DispatchKeys(t, SUBSCRIPTION_CONDITION_VALUES_MAX))
+			e.index.remove(t, ec.ID[i])
+			delete(e.ids, ec.ID[i])
+
+			if !ec.Properties[i].Auto {
+				atomic.AddInt32(e.count, -1)
+			}
 		}
 
+		e.metric.WithLabelValues(metricObject(t)).Sub(float64(len(ec.ID)))
+
+		ec.cancel()
+		delete(e.m, t)
+
 		return 0, nil
 	}
 
-	ec, exists := e.m[t]
-	if !exists {
-		return 0, ErrNotSubscribed
+	for i, c := range ec.Conditions {
+		if c.Includes(cond) {
+			id := ec.ID[i]
+
+			e.remove(t, ec, i)
+
+			return id, nil
+		}
 	}
 
-	var id uint32
+	return 0, ErrNotSubscribed
+}
 
-	matched := false
+// UnsubscribeWithID removes the subscriptions with the specified IDs,
+// returning ErrNotSubscribed if none of them exist
+func (e *EventMap) UnsubscribeWithID(id ...uint32) error {
+	e.mx.Lock()
+	defer e.mx.Unlock()
 
-	for i, c := range ec.Conditions {
-		if c.Match(cond) {
-			id = ec.ID[i]
+	found := false
+
+	for _, id := range id {
+		if e.removeID(id, nil) {
+			found = true
+		}
+	}
+
+	if !found {
+		return ErrNotSubscribed
+	}
+
+	return nil
+}
+
+// expire removes the subscriptions whose TTL has passed
+func (e *EventMap) expire(now time.Time, ids []uint32) {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+
+	for _, id := range ids {
+		// skip subscriptions that were extended or replaced since being scheduled
+		e.removeID(id, func(props EventSubscriptionProperties) bool {
+			return !props.TTL.IsZero() && !props.TTL.After(now)
+		})
+	}
+}
 
-			ec.ID = utils.SliceRemove(ec.ID, i)
-			ec.Conditions = utils.SliceRemove(ec.Conditions, i)
-			ec.Properties = utils.SliceRemove(ec.Properties, i)
+// removeID removes the subscription with the specified ID if it passes the filter.
+// the lock must be held
+func (e *EventMap) removeID(id uint32, filter func(props EventSubscriptionProperties) bool) bool {
+	t, ok := e.ids[id]
+	if !ok {
+		return false
+	}
 
-			e.subscription.Unsubscribe(events.CreateDispatchKey(t, c, false))
+	ec := e.m[t]
+	for i, v := range ec.ID {
+		if v != id {
+			continue
+		}
 
-			matched = true
-			break
+		if filter != nil && !filter(ec.Properties[i]) {
+			return false
 		}
+
+		e.remove(t, ec, i)
+
+		return true
 	}
 
-	if !matched {
-		return 0, ErrNotSubscribed
+	return false
+}
+
+// remove deletes the subscription at index i of an event channel,
+// cancelling the channel if it has no subscriptions left. the lock must be held
+func (e *EventMap) remove(t events.EventType, ec EventChannel, i int) {
+	e.removeKeys(ec.Conditions[i].DispatchKeys(t, SUBSCRIPTION_CONDITION_VALUES_MAX))
+	e.index.remove(t, ec.ID[i])
+	delete(e.ids, ec.ID[i])
+	e.metric.WithLabelValues(metricObject(t)).Dec()
+
+	if !ec.Properties[i].Auto {
+		atomic.AddInt32(e.count, -1)
 	}
 
+	ec.ID = utils.SliceRemove(ec.ID, i)
+	ec.Conditions = utils.SliceRemove(ec.Conditions, i)
+	ec.Properties = utils.SliceRemove(ec.Properties, i)
+
 	if len(ec.ID) == 0 {
 		ec.cancel()
 		delete(e.m, t)
 	} else {
 		e.m[t] = ec
 	}
+}
 
-	return id, nil
+// addKeys subscribes to the dispatch keys of a subscription. the lock must be held
+func (e *EventMap) addKeys(keys []string) {
+	for _, k := range keys {
+		if e.keys[k]++; e.keys[k] == 1 {
+			e.subscription.Subscribe(k)
+		}
+	}
 }
 
-func (e *EventMap) UnsubscribeWithID(id ...uint32) error {
-	var found bool
+// removeKeys unsubscribes from the dispatch keys no longer used by any subscription. the lock must be held
+func (e *EventMap) removeKeys(keys []string) {
+	for _, k := range keys {
+		if e.keys[k]--; e.keys[k] <= 0 {
+			delete(e.keys, k)
+			e.subscription.Unsubscribe(k)
+		}
+	}
+}
+
+// Keys returns the dispatch keys of the active subscriptions
+func (e *EventMap) Keys() []string {
 	e.mx.Lock()
 	defer e.mx.Unlock()
 
-outer:
-	for t, value := range e.m {
-		for _, id := range id {
-			for i, v := range value.ID {
-				if v == id {
-					e.subscription.Unsubscribe(events.CreateDispatchKey(t, value.Conditions[i], false))
+	keys := make([]string, 0, len(e.keys))
+	for k := range e.keys {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
 
-					value.ID = utils.SliceRemove(value.ID, i)
-					value.Conditions = utils.SliceRemove(value.Conditions, i)
-					value.Properties = utils.SliceRemove(value.Properties, i)
+// Dropped returns the number of dispatches for the connection dropped since the previous call
+// because it was too slow to receive them. Those matching none of its subscriptions are not counted,
+// while those beyond the ones retained by the broker are assumed to match
+func (e *EventMap) Dropped() uint64 {
+	msgs, n := e.subscription.Dropped()
 
-					found = true
-					break outer
-				}
-			}
+	var matches []uint32
+
+	for _, m := range msgs {
+		if err := m.Decode(); err != nil {
+			continue
 		}
-	}
 
-	if !found {
-		return ErrNotSubscribed
+		if d := m.Dispatch.Data; d.Whisper != "" {
+			n += uint64(utils.Ternary(d.Whisper == e.sessionID, 1, 0))
+		} else if matches = e.Match(d.Type, d.Conditions, matches[:0]); len(matches) > 0 {
+			n++
+		}
 	}
 
-	return nil
+	return n
 }
 
 func (e *EventMap) Count() int32 {
-	return *e.count
+	return atomic.LoadInt32(e.count)
 }
 
-func (e *EventMap) Get(t events.EventType) (*EventChannel, bool) {
+// Match appends the IDs of the subscriptions matched by a dispatch with the specified type and conditions to dst,
+// including the subscriptions to all events of the type's object
+func (e *EventMap) Match(t events.EventType, conds []events.EventCondition, dst []uint32) []uint32 {
 	e.mx.Lock()
 	defer e.mx.Unlock()
 
-	var ec *EventChannel
-
-	if c, ok := e.m[t]; ok {
-		ec = &c
-	}
-
-	tWilcard := events.EventType(fmt.Sprintf("%s.*", t.ObjectName()))
-	if c, ok := e.m[tWilcard]; ok {
-		if ec == nil {
-			ec = &EventChannel{
-				ID:         []uint32{},
-				Conditions: []events.EventCondition{},
-				Properties: []EventSubscriptionProperties{},
-			}
-		}
-
-		ec.ID = append(ec.ID, c.ID...)
-		ec.Conditions = append(ec.Conditions, c.Conditions...)
-		ec.Properties = append(ec.Properties, c.Properties...)
-	}
-
-	return ec, ec != nil
+	return e.index.match(t, conds, dst)
 }
 
-func (e *EventMap) DispatchChannel() chan []byte {
-	return e.subscription.Ch
+func (e *EventMap) DispatchChannel() chan *instance.BrokerMessage {
+	return e.subscription.Channel()
 }
 
 func (e *EventMap) Destroy(gctx global.Context) {
@@ -273,11 +475,20 @@ func (e *EventMap) Destroy(gctx global.Context) {
 		e.mx.Lock()
 		defer e.mx.Unlock()
 
+		e.expiry.Stop()
+
 		for key, value := range e.m {
 			value.cancel()
 			delete(e.m, key)
+
+			e.metric.WithLabelValues(metricObject(key)).Sub(float64(len(value.ID)))
 		}
 
+		e.index = newSubscriptionIndex()
+		e.ids = map[uint32]events.EventType{}
+		e.keys = map[string]int{}
+		atomic.StoreInt32(e.count, 0)
+
 		e.subscription.Close()
 	})
 }
@@ -286,36 +497,29 @@ type EventChannel struct {
 	ctx        context.Context
 	cancel     context.CancelFunc
 	ID         []uint32                      `json:"id"`
-	Conditions []events.EventCondition       `json:"conditions"`
+	Conditions []Condition                   `json:"conditions"`
 	Properties []EventSubscriptionProperties `json:"properties"`
 }
 
+// DispatchMessage is the wire format of a dispatch, carrying the stream
+// sequence it was published at when the broker retains messages
+type DispatchMessage struct {
+	events.Message[json.RawMessage]
+	StreamSequence uint64 `json:"stream_seq,omitempty"`
+}
+
 type EventSubscriptionProperties struct {
+	// The ID requested for the subscription, generated if zero
+	ID   uint32
 	TTL  time.Time
 	Auto bool
 }
 
-func (ec EventChannel) Match(cond []events.EventCondition) []uint32 {
-	if len(ec.Conditions) == 0 { // No condition
-		return ec.ID
-	}
-
-	matches := make(utils.Set[uint32], 0)
-
-	for _, c := range cond {
-		for i, e := range ec.Conditions {
-			if e.Match(c) && len(ec.ID) >= i {
-				matches.Add(ec.ID[i])
-			}
-		}
-	}
-
-	return matches.Values()
-}
-
 var (
-	ErrAlreadySubscribed = fmt.Errorf("already subscribed")
-	ErrNotSubscribed     = fmt.Errorf("not subscribed")
+	ErrAlreadySubscribed   = fmt.Errorf("already subscribed")
+	ErrNotSubscribed       = fmt.Errorf("not subscribed")
+	ErrSubscriptionIDTaken = fmt.Errorf("subscription id already in use")
+	ErrReplayUnavailable   = fmt.Errorf("the broker does not retain messages")
 )
 
 type Transport string
@@ -0,0 +1,465 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/seventv/api/data/events"
+
+	"github.com/seventv/eventapi/internal/broker"
+	"github.com/seventv/eventapi/internal/configure"
+	"github.com/seventv/eventapi/internal/global"
+	"github.com/seventv/eventapi/internal/monitoring"
+)
+
+func newTestEventMap(t testing.TB) (global.Context, *EventMap) {
+	t.Helper()
+
+	gctx := global.New(context.Background(), &configure.Config{})
+	gctx.Inst().Broker = broker.NewMemory()
+	gctx.Inst().Monitoring = monitoring.NewPrometheus(gctx)
+
+	return gctx, NewEventMap(gctx, "test")
+}
+
+// gaugeTotal sums the subscriptions gauge across its labels
+func gaugeTotal(t *testing.T, g *prometheus.GaugeVec) float64 {
+	t.Helper()
+
+	ch := make(chan prometheus.Metric, 16)
+
+	go func() {
+		g.Collect(ch)
+		close(ch)
+	}()
+
+	total := 0.0
+
+	for m := range ch {
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			t.Fatal(err)
+		}
+
+		total += pb.GetGauge().GetValue()
+	}
+
+	return total
+}
+
+func TestEventMapUnsubscribeWithID(t *testing.T) {
+	gctx, e := newTestEventMap(t)
+	defer e.Destroy(gctx)
+
+	t1 := events.EventType("emote_set.update")
+	t2 := events.EventType("user.update")
+
+	for i, cond := range []Condition{
+		NewCondition(events.EventCondition{"object_id": "1"}),
+		NewCondition(events.EventCondition{"object_id": "2"}),
+	} {
+		if _, _, err := e.Subscribe(gctx, context.Background(), t1, cond, EventSubscriptionProperties{ID: uint32(i + 1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, _, err := e.Subscribe(gctx, context.Background(), t2, nil, EventSubscriptionProperties{ID: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.UnsubscribeWithID(1, 3); err != nil {
+		t.Fatalf("UnsubscribeWithID(1, 3) = %v", err)
+	}
+
+	if n := e.Count(); n != 1 {
+		t.Errorf("Count() = %d after removing 2 of 3 subscriptions, want 1", n)
+	}
+
+	if got := e.Match(t1, []events.EventCondition{{"object_id": "1"}}, nil); len(got) != 0 {
+		t.Errorf("a removed subscription still matches: %v", got)
+	}
+
+	if got := e.Match(t1, []events.EventCondition{{"object_id": "2"}}, nil); len(got) != 1 || got[0] != 2 {
+		t.Errorf("Match() = %v, want [2]", got)
+	}
+
+	if keys := e.Keys(); len(keys) != 1 {
+		t.Errorf("Keys() = %v, want the key of the remaining subscription only", keys)
+	}
+
+	if g := gaugeTotal(t, e.metric); g != 1 {
+		t.Errorf("subscriptions gauge = %v, want 1", g)
+	}
+}
+
+func TestEventMapUnsubscribeWithUnknownID(t *testing.T) {
+	gctx, e := newTestEventMap(t)
+	defer e.Destroy(gctx)
+
+	if _, _, err := e.Subscribe(gctx, context.Background(), "user.update", nil, EventSubscriptionProperties{ID: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.UnsubscribeWithID(2); err != ErrNotSubscribed {
+		t.Errorf("UnsubscribeWithID(2) = %v, want %v", err, ErrNotSubscribed)
+	}
+
+	// removing the same ID twice fails the second time
+	if err := e.UnsubscribeWithID(1); err != nil {
+		t.Fatalf("UnsubscribeWithID(1) = %v", err)
+	}
+
+	if err := e.UnsubscribeWithID(1); err != ErrNotSubscribed {
+		t.Errorf("UnsubscribeWithID(1) = %v the second time, want %v", err, ErrNotSubscribed)
+	}
+
+	if n := e.Count(); n != 0 {
+		t.Errorf("Count() = %d, want 0", n)
+	}
+}
+
+func TestEventMapExpiry(t *testing.T) {
+	gctx, e := newTestEventMap(t)
+	defer e.Destroy(gctx)
+
+	now := time.Now()
+
+	// scheduled out of order to go through the heap
+	subs := []struct {
+		id  uint32
+		ttl time.Time
+	}{
+		{1, now.Add(150 * time.Millisecond)},
+		{2, now.Add(50 * time.Millisecond)},
+		{3, time.Time{}},
+	}
+
+	for _, s := range subs {
+		cond := NewCondition(events.EventCondition{"object_id": string(rune('0' + s.id))})
+
+		if _, _, err := e.Subscribe(gctx, context.Background(), "emote_set.update", cond, EventSubscriptionProperties{ID: s.id, TTL: s.ttl}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitCount := func(want int32) {
+		t.Helper()
+
+		deadline := time.Now().Add(2 * time.Second)
+		for e.Count() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("Count() = %d, want %d", e.Count(), want)
+			}
+
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	waitCount(2)
+
+	if err := e.UnsubscribeWithID(2); err != ErrNotSubscribed {
+		t.Errorf("subscription 2 should have expired first, UnsubscribeWithID(2) = %v", err)
+	}
+
+	waitCount(1)
+
+	if err := e.UnsubscribeWithID(1); err != ErrNotSubscribed {
+		t.Errorf("subscription 1 should have expired, UnsubscribeWithID(1) = %v", err)
+	}
+
+	if g := gaugeTotal(t, e.metric); g != 1 {
+		t.Errorf("subscriptions gauge = %v after expiry, want 1", g)
+	}
+}
+
+func TestEventMapDestroy(t *testing.T) {
+	gctx, e := newTestEventMap(t)
+
+	types := []events.EventType{"emote_set.update", "user.update", "emote.create"}
+
+	for i, et := range types {
+		if _, _, err := e.Subscribe(gctx, context.Background(), et, nil, EventSubscriptionProperties{ID: uint32(i + 1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// an auto subscription with a pending expiry
+	if _, _, err := e.Subscribe(gctx, context.Background(), "emote.update", nil, EventSubscriptionProperties{
+		ID:   10,
+		TTL:  time.Now().Add(time.Hour),
+		Auto: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if g := gaugeTotal(t, e.metric); g != 4 {
+		t.Fatalf("subscriptions gauge = %v, want 4", g)
+	}
+
+	e.Destroy(gctx)
+	e.Destroy(gctx)
+
+	if n := e.Count(); n != 0 {
+		t.Errorf("Count() = %d after Destroy, want 0", n)
+	}
+
+	if g := gaugeTotal(t, e.metric); g != 0 {
+		t.Errorf("subscriptions gauge = %v after Destroy, want 0", g)
+	}
+
+	if keys := e.Keys(); len(keys) != 0 {
+		t.Errorf("Keys() = %v after Destroy, want none", keys)
+	}
+}
+
+func TestEventMapMetricLabels(t *testing.T) {
+	gctx, e := newTestEventMap(t)
+	defer e.Destroy(gctx)
+
+	for i, et := range []events.EventType{"emote_set.update", "emote_set.create", "made.up", "another.one"} {
+		if _, _, err := e.Subscribe(gctx, context.Background(), et, nil, EventSubscriptionProperties{ID: uint32(i + 1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for label, want := range map[string]float64{"emote_set": 2, "other": 2} {
+		pb := &dto.Metric{}
+		if err := e.metric.WithLabelValues(label).Write(pb); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := pb.GetGauge().GetValue(); got != want {
+			t.Errorf("subscriptions{object=%q} = %v, want %v", label, got, want)
+		}
+	}
+}
+
+func TestEventMapDropped(t *testing.T) {
+	gctx, e := newTestEventMap(t)
+	defer e.Destroy(gctx)
+
+	const et = events.EventType("emote_set.update")
+
+	cond := NewCondition(events.EventCondition{"object_id": "1"})
+	if _, _, err := e.Subscribe(gctx, context.Background(), et, cond, EventSubscriptionProperties{ID: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	key := cond.DispatchKeys(et, SUBSCRIPTION_CONDITION_VALUES_MAX)[0]
+	mem := gctx.Inst().Broker.(*broker.Memory)
+
+	publish := func(n int, payload events.DispatchPayload) {
+		data, err := json.Marshal(events.Message[events.DispatchPayload]{Op: events.OpcodeDispatch, Data: payload})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < n; i++ {
+			mem.Publish(key, data)
+		}
+	}
+
+	matched := events.DispatchPayload{Type: et, Conditions: []events.EventCondition{{"object_id": "1"}}}
+
+	// fill the channel of the connection, which is never read
+	publish(cap(e.DispatchChannel()), matched)
+
+	if n := e.Dropped(); n != 0 {
+		t.Fatalf("Dropped() = %d before the channel is full, want 0", n)
+	}
+
+	publish(2, matched)
+	publish(3, events.DispatchPayload{Type: et, Conditions: []events.EventCondition{{"object_id": "2"}}})
+	publish(1, events.DispatchPayload{Type: et, Whisper: "test"})
+	publish(4, events.DispatchPayload{Type: et, Whisper: "another session"})
+
+	if n := e.Dropped(); n != 3 {
+		t.Errorf("Dropped() = %d, want the 2 matched dispatches and the whisper to the session", n)
+	}
+
+	if n := e.Dropped(); n != 0 {
+		t.Errorf("Dropped() = %d the second time, want the drops to be counted once", n)
+	}
+}
+
+// batchResults decodes the results of a batched command's ACK
+func batchResults(t *testing.T, ack json.RawMessage) []SubscriptionResult {
+	t.Helper()
+
+	var body struct {
+		Results []SubscriptionResult `json:"results"`
+	}
+
+	if err := json.Unmarshal(ack, &body); err != nil {
+		t.Fatal(err)
+	}
+
+	return body.Results
+}
+
+func TestSubscribeBatch(t *testing.T) {
+	gctx, conn := newTestConn(t, ProtocolModeStrict)
+	h := NewHandler(conn)
+
+	if err, ok := h.Subscribe(gctx, command(events.OpcodeSubscribe, `[
+		{"id": 1, "type": "emote_set.update", "condition": {"object_id": "1"}},
+		{"type": "emote_set.update", "condition": {"object_id": "1"}},
+		{"id": 1, "type": "user.update", "condition": {"object_id": "2"}},
+		{"type": 1},
+		{"type": "user"},
+		{"type": "user.update", "condition": {"object_id": ["2", "3"]}}
+	]`)); err != nil || !ok {
+		t.Fatalf("Subscribe() = %v, %t", err, ok)
+	}
+
+	if len(conn.errors) != 0 || len(conn.closes) != 0 || len(conn.acks) != 1 {
+		t.Fatalf("sent %d acks, errors %v and closes %v, want a single ack", len(conn.acks), conn.errors, conn.closes)
+	}
+
+	results := batchResults(t, conn.acks[0])
+
+	want := []ErrorCode{0, ErrorCodeAlreadySubscribed, ErrorCodeSubscriptionIDTaken, ErrorCodeInvalidPayload, ErrorCodeBadEventType, 0}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want one per item", len(results))
+	}
+
+	for i, r := range results {
+		switch {
+		case want[i] == 0 && r.Error != nil:
+			t.Errorf("item %d failed with %d", i, r.Error.Code)
+		case want[i] != 0 && (r.Error == nil || r.Error.Code != want[i]):
+			t.Errorf("item %d resulted in %+v, want error %d", i, r.Error, want[i])
+		}
+	}
+
+	if results[0].ID != 1 || results[5].ID == 0 || results[5].ID == 1 {
+		t.Errorf("subscribed with IDs %d and %d, want 1 and a generated one", results[0].ID, results[5].ID)
+	}
+
+	if results[5].Type != "user.update" || len(results[5].Condition["object_id"].Values) != 2 {
+		t.Errorf("result %+v does not echo its item", results[5])
+	}
+
+	if n := conn.evm.Count(); n != 2 {
+		t.Errorf("Count() = %d, want the 2 valid items subscribed", n)
+	}
+}
+
+func TestUnsubscribeBatch(t *testing.T) {
+	gctx, conn := newTestConn(t, ProtocolModeStrict)
+	h := NewHandler(conn)
+
+	if err, ok := h.Subscribe(gctx, command(events.OpcodeSubscribe, `[
+		{"id": 1, "type": "emote_set.update", "condition": {"object_id": "1"}},
+		{"id": 2, "type": "user.update", "condition": {"object_id": "2"}}
+	]`)); err != nil || !ok {
+		t.Fatalf("Subscribe() = %v, %t", err, ok)
+	}
+
+	if err := h.Unsubscribe(gctx, command(events.OpcodeUnsubscribe, `[
+		{"id": 1},
+		{"type": "user.update", "condition": {"object_id": "2"}},
+		{"id": 1},
+		{"type": []}
+	]`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(conn.errors) != 0 || len(conn.closes) != 0 || len(conn.acks) != 2 {
+		t.Fatalf("sent %d acks, errors %v and closes %v, want an ack per batch", len(conn.acks), conn.errors, conn.closes)
+	}
+
+	results := batchResults(t, conn.acks[1])
+
+	want := []ErrorCode{0, 0, ErrorCodeNotSubscribed, ErrorCodeInvalidPayload}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want one per item", len(results))
+	}
+
+	for i, r := range results {
+		if (r.Error == nil) != (want[i] == 0) || (r.Error != nil && r.Error.Code != want[i]) {
+			t.Errorf("item %d resulted in %+v, want error %d", i, r.Error, want[i])
+		}
+	}
+
+	if results[0].ID != 1 || results[1].ID != 2 {
+		t.Errorf("removed IDs %d and %d, want 1 and 2", results[0].ID, results[1].ID)
+	}
+
+	if n := conn.evm.Count(); n != 0 {
+		t.Errorf("Count() = %d, want 0", n)
+	}
+}
+
+func TestSubscribeBatchRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		code ErrorCode
+	}{
+		{"invalid list", `[{"type": "user.update"},]`, ErrorCodeInvalidPayload},
+		{"too many items", "[" + strings.TrimSuffix(strings.Repeat(`{"type": "user.update"},`, SUBSCRIPTION_BATCH_MAX+1), ",") + "]", ErrorCodeBatchTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gctx, conn := newTestConn(t, ProtocolModeStrict)
+
+			if err, ok := NewHandler(conn).Subscribe(gctx, command(events.OpcodeSubscribe, tt.data)); err != nil || ok {
+				t.Fatalf("Subscribe() = %v, %t, want the batch to be rejected", err, ok)
+			}
+
+			if len(conn.errors) != 1 || conn.errors[0].Code != tt.code || len(conn.acks) != 0 {
+				t.Errorf("sent errors %v and %d acks, want an error with code %d", conn.errors, len(conn.acks), tt.code)
+			}
+
+			if n := conn.evm.Count(); n != 0 {
+				t.Errorf("Count() = %d, want no item subscribed", n)
+			}
+		})
+	}
+}
+
+func TestSubscribeIDTaken(t *testing.T) {
+	for _, mode := range []ProtocolMode{ProtocolModeStrict, ProtocolModeLenient} {
+		t.Run(string(mode), func(t *testing.T) {
+			gctx, conn := newTestConn(t, mode)
+			h := NewHandler(conn)
+
+			if err, ok := h.Subscribe(gctx, command(events.OpcodeSubscribe, `{"id": 5, "type": "emote_set.update", "condition": {"object_id": "1"}}`)); err != nil || !ok {
+				t.Fatalf("Subscribe() = %v, %t", err, ok)
+			}
+
+			if err, ok := h.Subscribe(gctx, command(events.OpcodeSubscribe, `{"id": 5, "type": "user.update", "condition": {"object_id": "2"}}`)); err != nil || ok {
+				t.Fatalf("Subscribe() = %v, %t with an ID in use, want it to be rejected", err, ok)
+			}
+
+			if len(conn.errors) != 1 || conn.errors[0].Code != ErrorCodeSubscriptionIDTaken {
+				t.Fatalf("sent errors %v, want one with code %d", conn.errors, ErrorCodeSubscriptionIDTaken)
+			}
+
+			if id, ok := conn.errors[0].Fields["id"]; !ok || fmt.Sprint(id) != "5" {
+				t.Errorf("error fields %v, want the id in use", conn.errors[0].Fields)
+			}
+
+			if closed := len(conn.closes) != 0; closed != (mode == ProtocolModeStrict) {
+				t.Errorf("closed with %v in %s mode", conn.closes, mode)
+			}
+
+			// the subscription holding the ID is kept
+			if info, ok := conn.evm.Get(5); !ok || info.Type != "emote_set.update" {
+				t.Errorf("Get(5) = %+v, %t, want the first subscription", info, ok)
+			}
+
+			if n := conn.evm.Count(); n != 1 {
+				t.Errorf("Count() = %d, want 1", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/seventv/api/data/events"
+)
+
+// ErrorCode identifies an error reported to clients. Codes are stable and never reused
+type ErrorCode uint16
+
+const (
+	ErrorCodeInternal             ErrorCode = 1000
+	ErrorCodeInvalidMessage       ErrorCode = 1001
+	ErrorCodeInvalidPayload       ErrorCode = 1002
+	ErrorCodeMissingEventType     ErrorCode = 1003
+	ErrorCodeBadEventType         ErrorCode = 1004
+	ErrorCodeWildcardUnauthorized ErrorCode = 1005
+	ErrorCodeSubscriptionLimit    ErrorCode = 1006
+	ErrorCodeEventTypeTooLarge    ErrorCode = 1007
+	ErrorCodeConditionTooLarge    ErrorCode = 1008
+	ErrorCodeConditionKeyTooLarge ErrorCode = 1009
+	ErrorCodeAlreadySubscribed    ErrorCode = 1010
+	ErrorCodeNotSubscribed        ErrorCode = 1011
+	ErrorCodeBatchTooLarge        ErrorCode = 1012
+	ErrorCodeInvalidCondition     ErrorCode = 1013
+	ErrorCodeResumeFailed         ErrorCode = 1014
+	ErrorCodeReplayFailed         ErrorCode = 1015
+	ErrorCodeSubscriptionFailed   ErrorCode = 1016
+	ErrorCodeSubscriptionIDTaken  ErrorCode = 1017
+	ErrorCodeHistoryUnavailable   ErrorCode = 1018
+)
+
+// ErrorDocsURL documents the error codes
+const ErrorDocsURL = "https://github.com/SevenTV/EventAPI#error-codes"
+
+type errorEntry struct {
+	message string
+	// the code the connection is closed with in strict mode, zero if the error isn't fatal
+	closeCode events.CloseCode
+}
+
+var errorCatalogue = map[ErrorCode]errorEntry{
+	ErrorCodeInternal:             {"Internal Server Error", events.CloseCodeServerError},
+	ErrorCodeInvalidMessage:       {"Invalid Message", events.CloseCodeInvalidPayload},
+	ErrorCodeInvalidPayload:       {"Invalid Subscription Payload", events.CloseCodeInvalidPayload},
+	ErrorCodeMissingEventType:     {"Missing event type", events.CloseCodeInvalidPayload},
+	ErrorCodeBadEventType:         {"Bad event type path", events.CloseCodeInvalidPayload},
+	ErrorCodeWildcardUnauthorized: {"Wildcard event target subscription requires authentication", events.CloseCodeInsufficientPrivilege},
+	ErrorCodeSubscriptionLimit:    {"Too Many Active Subscriptions!", events.CloseCodeRateLimit},
+	ErrorCodeEventTypeTooLarge:    {"Event Type Too Large", events.CloseCodeRateLimit},
+	ErrorCodeConditionTooLarge:    {"Subscription Condition Too Large", events.CloseCodeRateLimit},
+	ErrorCodeConditionKeyTooLarge: {"Subscription Condition Key Too Large", events.CloseCodeRateLimit},
+	ErrorCodeAlreadySubscribed:    {"Already subscribed to this event", events.CloseCodeAlreadySubscribed},
+	ErrorCodeNotSubscribed:        {"Not subscribed to this event", events.CloseCodeNotSubscribed},
+	ErrorCodeBatchTooLarge:        {"Subscription Batch Too Large", events.CloseCodeRateLimit},
+	ErrorCodeInvalidCondition:     {"Invalid Subscription Condition", events.CloseCodeInvalidPayload},
+	ErrorCodeResumeFailed:         {"Resume Failed", 0},
+	ErrorCodeReplayFailed:         {"Replay Failed", 0},
+	ErrorCodeSubscriptionFailed:   {"Subscription Failed", events.CloseCodeServerError},
+	ErrorCodeSubscriptionIDTaken:  {"Subscription ID Already In Use", events.CloseCodeAlreadySubscribed},
+	ErrorCodeHistoryUnavailable:   {"Message History Unavailable", 0},
+}
+
+// Error is the payload of an ERROR message
+type Error struct {
+	Code    ErrorCode      `json:"code"`
+	Message string         `json:"message"`
+	Fields  map[string]any `json:"fields"`
+	Docs    string         `json:"docs"`
+	// The nonce of the command which caused the error
+	Nonce string `json:"nonce,omitempty"`
+	// The command which caused the error, echoed in lenient mode
+	Request *events.Message[json.RawMessage] `json:"request,omitempty"`
+
+	closeCode events.CloseCode
+	command   string
+}
+
+// NewError creates an error from the catalogue
+func NewError(code ErrorCode, fields map[string]any) *Error {
+	entry, ok := errorCatalogue[code]
+	if !ok {
+		code, entry = ErrorCodeInternal, errorCatalogue[ErrorCodeInternal]
+	}
+
+	if fields == nil {
+		fields = map[string]any{}
+	}
+
+	return &Error{
+		Code:      code,
+		Message:   entry.message,
+		Fields:    fields,
+		Docs:      ErrorDocsURL,
+		closeCode: entry.closeCode,
+	}
+}
+
+// CloseCode returns the code the connection is closed with in strict mode, zero if the error isn't fatal
+func (e *Error) CloseCode() events.CloseCode {
+	return e.closeCode
+}
+
+// Command returns the name of the command which caused the error, empty if unknown
+func (e *Error) Command() string {
+	return e.command
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d: %s", e.Code, e.Message)
+}
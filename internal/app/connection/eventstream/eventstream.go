@@ -6,7 +6,9 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -15,60 +17,96 @@ import (
 	"github.com/seventv/api/data/events"
 	"github.com/seventv/common/structures/v3"
 	"github.com/seventv/common/utils"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 
 	client "github.com/seventv/eventapi/internal/app/connection"
 	"github.com/seventv/eventapi/internal/global"
+	"github.com/seventv/eventapi/internal/instance"
+	"github.com/seventv/eventapi/internal/tracing"
+	"github.com/seventv/eventapi/internal/util"
 )
 
 type EventStream struct {
 	r                 *http.Request
+	conn              net.Conn
 	f                 http.Flusher
 	ctx               context.Context
+	span              trace.Span // the span of the connection, ended once it's destroyed
 	gctx              global.Context
 	cancel            context.CancelFunc
 	seq               int64
+	streamSeq         uint64
+	replayable        bool
 	handler           client.Handler
 	evm               *client.EventMap
 	cache             client.Cache
 	evbuf             client.EventBuffer
 	writeMtx          *sync.Mutex
 	writer            *bufio.Writer
+	buf               []byte    // reused for writing dispatches, guarded by writeMtx
+	lastWrite         time.Time // guarded by writeMtx
+	dead              bool      // whether a write failed, guarded by writeMtx
+	keepaliveInterval time.Duration
+	writeTimeout      time.Duration
 	ready             chan struct{}
 	readyOnce         sync.Once
 	sessionID         []byte
 	heartbeatInterval uint32
 	heartbeatCount    uint64
 	subscriptionLimit int32
+	mode              client.ProtocolMode
+	metrics           instance.EventV3
+	timestamps        bool // whether dispatches carry the time they are sent at
 }
 
 func NewEventStream(gctx global.Context, r *http.Request) (client.Connection, error) {
-	hbi := gctx.Config().API.HeartbeatInterval
-	if hbi == 0 {
-		hbi = 45000
-	}
+	cfg := gctx.Config().API
 
 	sessionID, err := client.GenerateSessionID(32)
 	if err != nil {
 		return nil, err
 	}
 
-	lctx, cancel := context.WithCancel(context.Background())
+	tctx, span := tracing.StartConnection(context.Background(), propagation.HeaderCarrier(r.Header), string(client.TransportEventStream))
+
+	lctx, cancel := context.WithCancel(tctx)
 	es := &EventStream{
 		r:                 r,
+		conn:              util.GetConn(r),
 		ctx:               lctx,
+		span:              span,
 		gctx:              gctx,
 		cancel:            cancel,
 		seq:               0,
-		evm:               client.NewEventMap(string(sessionID)),
+		evm:               client.NewEventMap(gctx, string(sessionID)),
 		cache:             client.NewCache(),
 		writeMtx:          &sync.Mutex{},
 		writer:            nil,
 		ready:             make(chan struct{}),
 		sessionID:         sessionID,
-		heartbeatInterval: hbi,
+		heartbeatInterval: cfg.HeartbeatInterval,
 		heartbeatCount:    0,
-		subscriptionLimit: gctx.Config().API.SubscriptionLimit,
+		subscriptionLimit: cfg.SubscriptionLimit,
+		mode:              client.ParseProtocolMode(r),
+		metrics:           gctx.Inst().Monitoring.EventV3(),
+		timestamps:        client.TimestampsRequested(r),
+	}
+
+	es.evm.Watch(client.WatchRequested(r))
+
+	if cfg.EventStream.KeepaliveInterval >= 0 {
+		es.keepaliveInterval = time.Duration(cfg.EventStream.KeepaliveInterval) * time.Millisecond
+	}
+
+	es.writeTimeout = time.Duration(cfg.EventStream.WriteTimeout) * time.Millisecond
+
+	// With a replayable broker the event ids are stream sequences,
+	// letting a reconnecting client recover missed dispatches via Last-Event-ID
+	if broker, ok := gctx.Inst().Broker.(instance.ReplayableBroker); ok {
+		es.replayable = true
+		es.streamSeq = broker.LastSequence()
 	}
 
 	es.handler = client.NewHandler(es)
@@ -101,6 +139,8 @@ func (es *EventStream) SendClose(code events.CloseCode, after time.Duration) {
 
 	defer es.Destroy()
 
+	es.metrics.Closes.WithLabelValues(string(es.Transport()), code.String()).Inc()
+
 	msg := events.NewMessage(events.OpcodeEndOfStream, events.EndOfStreamPayload{
 		Code:    code,
 		Message: code.String(),
@@ -154,25 +194,36 @@ func (es *EventStream) SendHeartbeat() error {
 }
 
 // SendAck implements client.Connection
-func (es *EventStream) SendAck(cmd events.Opcode, data json.RawMessage) error {
-	msg := events.NewMessage(events.OpcodeAck, events.AckPayload{
-		Command: cmd.String(),
+func (es *EventStream) SendAck(cmd events.Opcode, data json.RawMessage, nonce string) error {
+	msg := events.NewMessage(events.OpcodeAck, json.RawMessage(utils.ToJSON(client.AckPayload{
+		Command: client.OpcodeName(cmd),
 		Data:    data,
-	})
+		Nonce:   nonce,
+	})))
 
-	return es.Write(msg.ToRaw())
+	if err := es.Write(msg); err != nil {
+		return err
+	}
+
+	es.metrics.Acks.WithLabelValues(client.OpcodeName(cmd)).Inc()
+
+	return nil
 }
 
-func (es *EventStream) SendError(txt string, fields map[string]any) {
-	if fields == nil {
-		fields = make(map[string]any)
-	}
-	msg := events.NewMessage(events.OpcodeError, events.ErrorPayload{
-		Message: txt,
-		Fields:  fields,
-	})
+// ReplayHistory implements client.Connection
+//
+// EventStream clients recover missed events by reconnecting with Last-Event-ID instead
+func (es *EventStream) ReplayHistory(after uint64) (client.HistoryResult, error) {
+	return client.HistoryResult{}, client.ErrHistoryUnavailable
+}
 
-	if err := es.Write(msg.ToRaw()); err != nil {
+// SendError implements client.Connection
+func (es *EventStream) SendError(e *client.Error) {
+	es.metrics.Errors.WithLabelValues(e.Command(), strconv.Itoa(int(e.Code))).Inc()
+
+	msg := events.NewMessage(events.OpcodeError, json.RawMessage(utils.ToJSON(e)))
+
+	if err := es.Write(msg); err != nil {
 		zap.S().Errorw("failed to write an error message to the socket", "error", err)
 	}
 }
@@ -187,28 +238,127 @@ func (es *EventStream) Write(msg events.Message[json.RawMessage]) error {
 	}
 
 	sb := strings.Builder{}
-	_, er1 := sb.WriteString(fmt.Sprintf("event: %s\ndata: ", strings.ToLower(msg.Op.String())))
+	_, er1 := sb.WriteString(fmt.Sprintf("event: %s\ndata: ", strings.ToLower(client.OpcodeName(msg.Op))))
 	_, er2 := sb.Write(b)
-	_, er3 := sb.WriteString(fmt.Sprintf("\nid: %d", es.seq))
-	_, er4 := sb.WriteString("\n\n")
-	if err = multierror.Append(er1, er2, er3, er4).ErrorOrNil(); err != nil {
+	if err = multierror.Append(er1, er2).ErrorOrNil(); err != nil {
+		return err
+	}
+
+	es.writeMtx.Lock()
+	defer es.writeMtx.Unlock()
+
+	return es.writeEvent(utils.S2B(sb.String()))
+}
+
+// WriteDispatch implements client.Connection
+func (es *EventStream) WriteDispatch(msg *instance.BrokerMessage, matches []uint32) error {
+	if es.writer == nil {
+		return fmt.Errorf("connection not writable")
+	}
+
+	frame, err := client.NewDispatchFrame(msg, client.TransportEventStream, func(m events.Message[json.RawMessage]) ([]byte, error) {
+		return append([]byte("event: dispatch\ndata: "), m.Data...), nil
+	})
+	if err != nil {
 		return err
 	}
 
-	if _, err = es.writer.Write(utils.S2B(sb.String())); err != nil {
-		zap.S().Errorw("failed to write to event stream connection", "error", err)
+	es.writeMtx.Lock()
+	defer es.writeMtx.Unlock()
+
+	if msg.Sequence > es.streamSeq {
+		es.streamSeq = msg.Sequence
 	}
 
-	if err = es.writer.Flush(); err != nil {
+	start := time.Now()
+
+	es.buf = frame.Append(es.buf[:0], matches, utils.Ternary(es.timestamps, start.UnixMilli(), 0))
+	if err := es.writeEvent(es.buf); err != nil {
 		return err
 	}
 
-	es.f.Flush()
+	es.metrics.WriteLatency.WithLabelValues(string(es.Transport())).Observe(time.Since(start).Seconds())
+
+	es.metrics.Dispatches.WithLabelValues(string(msg.Dispatch.Data.Type), string(es.Transport())).Inc()
+
+	return nil
+}
+
+// writeEvent terminates an event with its id and flushes it to the client. writeMtx must be held
+func (es *EventStream) writeEvent(b []byte) error {
+	b = append(b, "\nid: "...)
+	b = strconv.AppendUint(b, utils.Ternary(es.replayable, es.streamSeq, uint64(es.seq)), 10)
+	b = append(b, "\n\n"...)
+
+	if err := es.flush(b); err != nil {
+		return err
+	}
 
 	es.seq++
 	return nil
 }
 
+// SendKeepalive writes a comment if nothing was written for the keepalive interval,
+// so that a dead stream is noticed by failing to write to it
+func (es *EventStream) SendKeepalive() error {
+	es.writeMtx.Lock()
+	defer es.writeMtx.Unlock()
+
+	if es.writer == nil || es.keepaliveInterval <= 0 || time.Since(es.lastWrite) < es.keepaliveInterval {
+		return nil
+	}
+
+	return es.flush([]byte(":\n\n"))
+}
+
+// flush writes to the client within the write timeout. writeMtx must be held
+//
+// The server cancels the request's context once writing to the underlying connection fails,
+// including when the write deadline is exceeded, which is how a dead stream is detected
+func (es *EventStream) flush(b []byte) error {
+	if es.dead {
+		return errStreamDead
+	}
+
+	// the client went away by itself
+	if err := es.r.Context().Err(); err != nil {
+		return err
+	}
+
+	if es.conn != nil {
+		_ = es.conn.SetWriteDeadline(time.Now().Add(es.writeTimeout))
+		defer func() {
+			_ = es.conn.SetWriteDeadline(time.Time{})
+		}()
+	}
+
+	_, err := es.writer.Write(b)
+	if err == nil {
+		err = es.writer.Flush()
+	}
+
+	if err == nil {
+		es.f.Flush()
+
+		err = es.r.Context().Err()
+	}
+
+	// the write failed or timed out: the client is unreachable
+	if err != nil {
+		es.dead = true
+		es.metrics.ReapedConnections.WithLabelValues(string(es.Transport())).Inc()
+
+		return err
+	}
+
+	es.lastWrite = time.Now()
+	es.metrics.BytesWritten.WithLabelValues(string(es.Transport())).Add(float64(len(b)))
+
+	return nil
+}
+
+var errStreamDead = fmt.Errorf("event stream is dead")
+
 // SetWriter implements Connection
 func (es *EventStream) SetWriter(w *bufio.Writer, f http.Flusher) {
 	es.writer = w
@@ -235,6 +385,7 @@ func (es *EventStream) Destroy() {
 	es.cancel()
 	es.SetReady()
 	es.evm.Destroy(es.gctx)
+	es.span.End()
 }
 
 func SetEventStreamHeaders(w http.ResponseWriter) {
@@ -254,3 +405,8 @@ func SetEventStreamHeaders(w http.ResponseWriter) {
 func (es *EventStream) Transport() client.Transport {
 	return client.TransportEventStream
 }
+
+// Mode implements client.Connection
+func (es *EventStream) Mode() client.ProtocolMode {
+	return es.mode
+}
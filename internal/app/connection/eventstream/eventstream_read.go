@@ -1,12 +1,8 @@
 package eventstream
 
 import (
-	"encoding/json"
 	"time"
 
-	"github.com/seventv/api/data/events"
-	"go.uber.org/zap"
-
 	"github.com/seventv/eventapi/internal/global"
 )
 
@@ -33,33 +29,27 @@ func (es *EventStream) Read(gctx global.Context) {
 			return
 		case <-es.OnClose():
 			return
-		case <-gctx.Done():
-			es.SendClose(events.CloseCodeRestart, time.Second*5)
-			return
 		case <-heartbeat.C:
-			gctx.Inst().Monitoring.EventV3().Heartbeats.Observe(1)
+			gctx.Inst().Monitoring.EventV3().Heartbeats.WithLabelValues(string(es.Transport())).Inc()
 
 			if err := es.SendHeartbeat(); err != nil {
 				return
 			}
 		case <-liveness.C: // Connection liveness check
+			if err := es.SendKeepalive(); err != nil {
+				return
+			}
 		case s := <-es.evm.DispatchChannel():
 			if s == nil { // channel closed
 				return
 			}
 
-			var msg events.Message[events.DispatchPayload]
-
-			err := json.Unmarshal(s, &msg)
-			if err != nil {
-				zap.S().Errorw("dispatch unmarshal error", "error", err)
-				continue
+			if !s.QueuedAt.IsZero() {
+				es.metrics.QueueLatency.WithLabelValues(string(es.Transport())).Observe(time.Since(s.QueuedAt).Seconds())
 			}
 
 			// Dispatch the event to the client
-			es.handler.OnDispatch(gctx, msg)
-
-			gctx.Inst().Monitoring.EventV3().Dispatches.Observe(1)
+			es.handler.OnDispatch(gctx, s)
 		}
 	}
 }
@@ -0,0 +1,117 @@
+package client
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// expiryScheduler removes subscriptions once their TTL passes,
+// using a single timer per connection
+type expiryScheduler struct {
+	mx      sync.Mutex
+	entries expiryHeap
+	timer   *time.Timer
+	stopped bool
+
+	// called with the entries that are due
+	expire func(now time.Time, ids []uint32)
+}
+
+type expiryEntry struct {
+	at time.Time
+	id uint32
+}
+
+func newExpiryScheduler(expire func(now time.Time, ids []uint32)) *expiryScheduler {
+	return &expiryScheduler{
+		expire: expire,
+	}
+}
+
+// Schedule queues the removal of a subscription at the specified time
+func (s *expiryScheduler) Schedule(id uint32, at time.Time) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.stopped {
+		return
+	}
+
+	heap.Push(&s.entries, expiryEntry{at: at, id: id})
+
+	// re-arm the timer if this is now the earliest entry
+	if s.entries[0].id == id && s.entries[0].at.Equal(at) {
+		s.arm()
+	}
+}
+
+// Stop cancels all pending removals
+func (s *expiryScheduler) Stop() {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.stopped = true
+	s.entries = nil
+
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+// arm sets the timer to fire at the earliest entry. the lock must be held
+func (s *expiryScheduler) arm() {
+	if len(s.entries) == 0 {
+		return
+	}
+
+	d := time.Until(s.entries[0].at)
+
+	if s.timer == nil {
+		s.timer = time.AfterFunc(d, s.fire)
+	} else {
+		s.timer.Reset(d)
+	}
+}
+
+func (s *expiryScheduler) fire() {
+	s.mx.Lock()
+
+	if s.stopped {
+		s.mx.Unlock()
+		return
+	}
+
+	now := time.Now()
+	ids := []uint32{}
+
+	for len(s.entries) > 0 && !s.entries[0].at.After(now) {
+		ids = append(ids, heap.Pop(&s.entries).(expiryEntry).id)
+	}
+
+	s.arm()
+	s.mx.Unlock()
+
+	if len(ids) > 0 {
+		s.expire(now, ids)
+	}
+}
+
+type expiryHeap []expiryEntry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h expiryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *expiryHeap) Push(x any) {
+	*h = append(*h, x.(expiryEntry))
+}
+
+func (h *expiryHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+
+	return x
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/seventv/api/data/events"
+
+	"github.com/seventv/eventapi/internal/instance"
+)
+
+// DispatchFrame is the encoding of a dispatch shared by all of its recipients on a transport.
+//
+// It is split at the end of the payload, where the subscription matches
+// of the receiving connection are inserted
+type DispatchFrame struct {
+	Head []byte
+	Tail []byte
+}
+
+// Append appends the frame to dst with the specified matches spliced in,
+// along with the time it is sent at in unix milliseconds unless sentAt is zero
+func (f *DispatchFrame) Append(dst []byte, matches []uint32, sentAt int64) []byte {
+	dst = append(dst, f.Head...)
+
+	if len(matches) > 0 {
+		dst = append(dst, `,"matches":[`...)
+
+		for i, id := range matches {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+
+			dst = strconv.AppendUint(dst, uint64(id), 10)
+		}
+
+		dst = append(dst, ']')
+	}
+
+	if sentAt != 0 {
+		dst = append(dst, `,"sent_at":`...)
+		dst = strconv.AppendInt(dst, sentAt, 10)
+	}
+
+	return append(dst, f.Tail...)
+}
+
+// Size returns the length of the frame without matches
+func (f *DispatchFrame) Size() int {
+	return len(f.Head) + len(f.Tail)
+}
+
+// DispatchPayload returns the client-facing encoding of a dispatch's payload,
+// stripped of the fields used internally and without matches
+func DispatchPayload(msg *instance.BrokerMessage) (json.RawMessage, error) {
+	v, err := msg.Frame("payload", func() (any, error) {
+		d := msg.Dispatch.Data
+
+		d.Conditions = nil
+		d.Effect = nil
+		d.Hash = nil
+		d.Whisper = ""
+		d.Matches = nil
+
+		b, err := json.Marshal(d)
+
+		return json.RawMessage(b), err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(json.RawMessage), nil
+}
+
+// NewDispatchFrame returns the frame of a dispatch for a transport, creating it
+// with encode the first time it's requested. encode receives the payload and must embed it verbatim
+func NewDispatchFrame(
+	msg *instance.BrokerMessage,
+	transport Transport,
+	encode func(msg events.Message[json.RawMessage]) ([]byte, error),
+) (*DispatchFrame, error) {
+	v, err := msg.Frame(string(transport), func() (any, error) {
+		d, err := DispatchPayload(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := encode(events.Message[json.RawMessage]{
+			Op:        events.OpcodeDispatch,
+			Timestamp: msg.Dispatch.Timestamp,
+			Data:      d,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// split before the closing brace of the payload
+		i := bytes.Index(b, d)
+		if i == -1 || len(d) < 2 || d[len(d)-1] != '}' {
+			return nil, fmt.Errorf("dispatch payload not found in frame")
+		}
+
+		i += len(d) - 1
+
+		return &DispatchFrame{
+			Head: b[:i],
+			Tail: b[i:],
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(*DispatchFrame), nil
+}
@@ -6,13 +6,18 @@ import (
 	"errors"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/seventv/api/data/events"
 	"github.com/seventv/common/utils"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 
 	"github.com/seventv/eventapi/internal/global"
+	"github.com/seventv/eventapi/internal/instance"
+	"github.com/seventv/eventapi/internal/tracing"
 )
 
 func NewHandler(conn Connection) Handler {
@@ -20,11 +25,19 @@ func NewHandler(conn Connection) Handler {
 }
 
 type Handler interface {
-	Subscribe(gctx global.Context, m events.Message[json.RawMessage]) (error, bool)
-	Unsubscribe(gctx global.Context, m events.Message[json.RawMessage]) error
-	OnDispatch(gctx global.Context, msg events.Message[events.DispatchPayload])
-	OnResume(gctx global.Context, msg events.Message[json.RawMessage]) error
-	OnBridge(gctx global.Context, msg events.Message[json.RawMessage]) error
+	Subscribe(gctx global.Context, m ClientMessage) (error, bool)
+	Unsubscribe(gctx global.Context, m ClientMessage) error
+	OnDispatch(gctx global.Context, msg *instance.BrokerMessage)
+	OnResume(gctx global.Context, msg ClientMessage) error
+	OnBridge(gctx global.Context, msg ClientMessage) error
+	// ListSubscriptions sends the active subscriptions of the connection to the client
+	ListSubscriptions(gctx global.Context, msg ClientMessage) error
+	// OnHeartbeat acknowledges a heartbeat sent by the client
+	OnHeartbeat(gctx global.Context, msg ClientMessage) error
+	// OnReplay sends the messages the client missed from the connection's history
+	OnReplay(gctx global.Context, msg ClientMessage) error
+	// Replay recovers the dispatches published after the given stream sequence
+	Replay(gctx global.Context, after uint64) (int, error)
 }
 
 type handler struct {
@@ -34,23 +47,48 @@ type handler struct {
 const (
 	EVENT_TYPE_MAX_LENGTH                   = 64
 	SUBSCRIPTION_CONDITION_MAX              = 10
+	SUBSCRIPTION_CONDITION_VALUES_MAX       = 256
 	SUBSCRIPTION_CONDITION_KEY_MAX_LENGTH   = 64
 	SUBSCRIPTION_CONDITION_VALUE_MAX_LENGTH = 128
+	SUBSCRIPTION_BATCH_MAX                  = 100
+	COMMAND_NONCE_MAX_LENGTH                = 128
 )
 
-func (h handler) OnDispatch(gctx global.Context, msg events.Message[events.DispatchPayload]) {
+// matchesPool holds the buffers subscription matches are collected in
+var matchesPool = sync.Pool{
+	New: func() any {
+		return utils.PointerOf(make([]uint32, 0, 16))
+	},
+}
+
+// OnDispatch filters a dispatch by the connection's subscriptions and writes it to the client.
+//
+// The message is shared with the other recipients and must not be modified
+func (h handler) OnDispatch(gctx global.Context, m *instance.BrokerMessage) {
+	if err := m.Decode(); err != nil {
+		zap.S().Errorw("dispatch unmarshal error",
+			"error", err,
+			"key", m.Key,
+		)
+
+		return
+	}
+
+	msg := &m.Dispatch
+
 	var matches []uint32
 
 	if msg.Data.Whisper == "" {
-		// Filter by subscribed event types
-		ev, ok := h.conn.Events().Get(msg.Data.Type)
-		if !ok {
-			return // skip if not subscribed to this
-		}
+		buf := matchesPool.Get().(*[]uint32)
+		defer func() {
+			*buf = matches[:0]
+			matchesPool.Put(buf)
+		}()
 
-		matches = ev.Match(msg.Data.Conditions)
+		// Filter by subscribed event types
+		matches = h.conn.Events().Match(msg.Data.Type, msg.Data.Conditions, (*buf)[:0])
 		if len(matches) == 0 {
-			return
+			return // skip if not subscribed to this
 		}
 	} else if msg.Data.Whisper != h.conn.SessionID() {
 		return // skip if event is whisper not for this session
@@ -67,57 +105,63 @@ func (h handler) OnDispatch(gctx global.Context, msg events.Message[events.Dispa
 
 	// Handle effect
 	if msg.Data.Effect != nil {
+		var added, removed []SubscriptionInfo
+
 		for _, e := range msg.Data.Effect.AddSubscriptions {
-			_, ids, err := h.conn.Events().Subscribe(gctx, h.conn.Context(), e.Type, e.Condition, EventSubscriptionProperties{
+			// subscriptions with a TTL are removed by the event map once it passes
+			_, id, err := h.conn.Events().Subscribe(gctx, h.conn.Context(), e.Type, NewCondition(e.Condition), EventSubscriptionProperties{
 				TTL:  utils.Ternary(e.TTL > 0, time.Now().Add(e.TTL), time.Time{}),
 				Auto: true,
 			})
-			if err != nil && !errors.Is(err, ErrAlreadySubscribed) {
-				zap.S().Errorw("failed to add subscription from dispatch",
-					"error", err,
-				)
+			if err != nil {
+				if !errors.Is(err, ErrAlreadySubscribed) {
+					zap.S().Errorw("failed to add subscription from dispatch",
+						"error", err,
+					)
+				}
+
+				continue
 			}
 
-			// Handle TTL: remove the subscription after TTL
-			if e.TTL > 0 {
-				go func(ttl time.Duration, typ events.EventType, cond events.EventCondition) {
-					select {
-					case <-h.conn.Context().Done():
-						return
-					case <-time.After(ttl):
-					}
-
-					err = h.conn.Events().UnsubscribeWithID(ids)
-					if err != nil && !errors.Is(err, ErrNotSubscribed) {
-						zap.S().Errorw("failed to remove subscription from dispatch after TTL expire",
-							"error", err,
-							"ttl", ttl.Milliseconds(),
-							"type", typ,
-							"condition", cond,
-						)
-					}
-				}(e.TTL, e.Type, e.Condition)
+			if info, ok := h.conn.Events().Get(id); ok {
+				added = append(added, info)
 			}
 		}
 
 		for _, e := range msg.Data.Effect.RemoveSubscriptions {
-			_, err := h.conn.Events().Unsubscribe(gctx, e.Type, e.Condition)
-			if err != nil && !errors.Is(err, ErrNotSubscribed) {
-				zap.S().Errorw("failed to remove subscription from dispatch",
-					"error", err,
-				)
+			cond := NewCondition(e.Condition)
+
+			id, err := h.conn.Events().Unsubscribe(gctx, e.Type, cond)
+			if err != nil {
+				if !errors.Is(err, ErrNotSubscribed) {
+					zap.S().Errorw("failed to remove subscription from dispatch",
+						"error", err,
+					)
+				}
+
+				continue
 			}
+
+			removed = append(removed, SubscriptionInfo{
+				ID:        id,
+				Type:      e.Type,
+				Condition: cond,
+			})
 		}
 
 		for _, ha := range msg.Data.Effect.RemoveHashes {
 			h.conn.Cache().ExpireDispatch(ha)
 		}
+
+		if h.conn.Buffer() == nil {
+			h.notifySubscriptions(added, removed)
+		}
 	}
 
 	// connections with a buffer are dead connections where dispatches
 	// are being saved to allow for a graceful recovery via resuming
 	if h.conn.Buffer() != nil {
-		if err := h.conn.Buffer().Push(gctx, msg); err != nil {
+		if err := h.conn.Buffer().Push(gctx, *msg); err != nil {
 			zap.S().Errorw("failed to push dispatch to buffer",
 				"error", err,
 			)
@@ -126,141 +170,161 @@ func (h handler) OnDispatch(gctx global.Context, msg events.Message[events.Dispa
 		return
 	}
 
-	msg.Data.Conditions = nil
-	msg.Data.Effect = nil
-	msg.Data.Hash = nil
-	msg.Data.Whisper = ""
-	msg.Data.Matches = matches
+	var span trace.Span
+	if tracing.SampleDispatch(m) {
+		_, span = tracing.StartDispatch(h.conn.Context(), m.SpanContext, string(msg.Data.Type))
+	}
 
-	if err := h.conn.Write(msg.ToRaw()); err != nil {
+	err := h.conn.WriteDispatch(m, matches)
+	if err != nil {
 		zap.S().Errorw("failed to write dispatch to connection",
 			"error", err,
 		)
 	}
+
+	if span != nil {
+		tracing.End(span, err)
+	}
 }
 
-func (h handler) Subscribe(gctx global.Context, m events.Message[json.RawMessage]) (error, bool) {
-	msg, err := events.ConvertMessage[events.SubscribePayload](m)
+func (h handler) Subscribe(gctx global.Context, m ClientMessage) (error, bool) {
+	if isBatch(m.Data) {
+		return h.subscribeBatch(gctx, m)
+	}
+
+	var payload SubscribePayload
+	if err := json.Unmarshal(m.Data, &payload); err != nil {
+		h.reject(m, invalidPayloadError(err))
+
+		return nil, false
+	}
+
+	id, serr, err := h.subscribe(gctx, payload)
 	if err != nil {
 		return err, false
 	}
 
-	t := msg.Data.Type
+	if serr != nil {
+		h.reject(m, serr)
+
+		return nil, false
+	}
+
+	_ = h.conn.SendAck(events.OpcodeSubscribe, utils.ToJSON(struct {
+		ID        uint32    `json:"id"`
+		Type      string    `json:"type"`
+		Condition Condition `json:"condition"`
+	}{
+		ID:        id,
+		Type:      string(payload.Type),
+		Condition: payload.Condition,
+	}), m.Nonce)
+
+	return nil, true
+}
+
+// subscribe validates and adds a subscription,
+// returning an Error if the client made a mistake
+func (h handler) subscribe(gctx global.Context, payload SubscribePayload) (uint32, *Error, error) {
+	t := payload.Type
 	path := strings.Split(string(t), ".")
 
 	// Empty subscription event type
 	if t == "" {
-		h.conn.SendError("Missing event type", nil)
-		h.conn.SendClose(events.CloseCodeInvalidPayload, 0)
-
-		return nil, false
+		return 0, NewError(ErrorCodeMissingEventType, nil), nil
 	}
 	if len(path) < 2 {
-		h.conn.SendError("Bad event type path", nil)
-		h.conn.SendClose(events.CloseCodeInvalidPayload, 0)
-
-		return nil, false
+		return 0, NewError(ErrorCodeBadEventType, nil), nil
 	}
 
 	// No targets: this requires authentication
-	if len(msg.Data.Condition) == 0 && h.conn.Actor() == nil {
-		h.conn.SendError("Wildcard event target subscription requires authentication", nil)
-		h.conn.SendClose(events.CloseCodeInsufficientPrivilege, 0)
-
-		return nil, false
+	if payload.Condition.Wildcard() && h.conn.Actor() == nil {
+		return 0, NewError(ErrorCodeWildcardUnauthorized, nil), nil
 	}
 
 	// Too many subscriptions?
 	if h.conn.Events().Count() >= gctx.Config().API.SubscriptionLimit {
-		h.conn.SendError("Too Many Active Subscriptions!", nil)
-		h.conn.SendClose(events.CloseCodeRateLimit, 0)
-
-		return nil, false
+		return 0, NewError(ErrorCodeSubscriptionLimit, nil), nil
 	}
 
 	// Validate: event type
-	if len(msg.Data.Type) > EVENT_TYPE_MAX_LENGTH {
-		h.conn.SendError("Event Type Too Large", map[string]any{
-			"event_type":             msg.Data.Type,
-			"event_type_length":      len(msg.Data.Type),
+	if len(payload.Type) > EVENT_TYPE_MAX_LENGTH {
+		return 0, NewError(ErrorCodeEventTypeTooLarge, map[string]any{
+			"event_type":             payload.Type,
+			"event_type_length":      len(payload.Type),
 			"event_type_length_most": EVENT_TYPE_MAX_LENGTH,
-		})
-		h.conn.SendClose(events.CloseCodeRateLimit, 0)
-
-		return nil, false
+		}), nil
 	}
 
 	// Validate: condition
+	if len(payload.Condition) > SUBSCRIPTION_CONDITION_MAX || payload.Condition.ValueCount() > SUBSCRIPTION_CONDITION_VALUES_MAX {
+		return 0, NewError(ErrorCodeConditionTooLarge, map[string]any{
+			"condition_keys":        len(payload.Condition),
+			"condition_keys_most":   SUBSCRIPTION_CONDITION_MAX,
+			"condition_values":      payload.Condition.ValueCount(),
+			"condition_values_most": SUBSCRIPTION_CONDITION_VALUES_MAX,
+		}), nil
+	}
+
 	pos := -1
-	for k, v := range msg.Data.Condition {
+	for k, cv := range payload.Condition {
 		pos++
 
-		if pos > SUBSCRIPTION_CONDITION_MAX {
-			h.conn.SendError("Subscription Condition Too Large", map[string]any{
-				"condition_keys":      len(msg.Data.Condition),
-				"condition_keys_most": SUBSCRIPTION_CONDITION_MAX,
-			})
-			h.conn.SendClose(events.CloseCodeRateLimit, 0)
-
-			return nil, false
-		}
-
-		kL := len(k)
-		vL := len(v)
-
-		if kL > SUBSCRIPTION_CONDITION_KEY_MAX_LENGTH || vL > SUBSCRIPTION_CONDITION_VALUE_MAX_LENGTH {
-			h.conn.SendError("Subscription Condition Key Too Large", map[string]any{
-				"key":               k,
-				"key_index":         pos,
-				"value":             v,
-				"key_length":        kL,
-				"key_length_most":   SUBSCRIPTION_CONDITION_KEY_MAX_LENGTH,
-				"value_length":      vL,
-				"value_length_most": SUBSCRIPTION_CONDITION_VALUE_MAX_LENGTH,
-			})
-			h.conn.SendClose(events.CloseCodeRateLimit, 0)
-
-			return nil, false
+		for _, v := range cv.Values {
+			kL := len(k)
+			vL := len(v)
+
+			if kL > SUBSCRIPTION_CONDITION_KEY_MAX_LENGTH || vL > SUBSCRIPTION_CONDITION_VALUE_MAX_LENGTH {
+				return 0, NewError(ErrorCodeConditionKeyTooLarge, map[string]any{
+					"key":               k,
+					"key_index":         pos,
+					"value":             v,
+					"key_length":        kL,
+					"key_length_most":   SUBSCRIPTION_CONDITION_KEY_MAX_LENGTH,
+					"value_length":      vL,
+					"value_length_most": SUBSCRIPTION_CONDITION_VALUE_MAX_LENGTH,
+				}), nil
+			}
 		}
 	}
 
 	// Add the event subscription
-	_, id, err := h.conn.Events().Subscribe(gctx, h.conn.Context(), t, msg.Data.Condition, EventSubscriptionProperties{})
+	_, id, err := h.conn.Events().Subscribe(gctx, h.conn.Context(), t, payload.Condition, EventSubscriptionProperties{
+		ID: payload.ID,
+	})
 	if err != nil {
 		switch err {
 		case ErrAlreadySubscribed:
-			h.conn.SendError("Already subscribed to this event", nil)
-			h.conn.SendClose(events.CloseCodeAlreadySubscribed, 0)
-
-			return nil, false
+			return 0, NewError(ErrorCodeAlreadySubscribed, nil), nil
+		case ErrSubscriptionIDTaken:
+			return 0, NewError(ErrorCodeSubscriptionIDTaken, map[string]any{
+				"id": payload.ID,
+			}), nil
 		default:
-			return err, false
+			return 0, nil, err
 		}
 	}
 
-	_ = h.conn.SendAck(events.OpcodeSubscribe, utils.ToJSON(struct {
-		ID        uint32            `json:"id"`
-		Type      string            `json:"type"`
-		Condition map[string]string `json:"condition"`
-	}{
-		ID:        id,
-		Type:      string(msg.Data.Type),
-		Condition: msg.Data.Condition,
-	}))
-
-	return nil, true
+	return id, nil, nil
 }
 
-func (h handler) Unsubscribe(gctx global.Context, m events.Message[json.RawMessage]) error {
-	msg, err := events.ConvertMessage[events.UnsubscribePayload](m)
-	if err != nil {
-		return err
+func (h handler) Unsubscribe(gctx global.Context, m ClientMessage) error {
+	if isBatch(m.Data) {
+		return h.unsubscribeBatch(gctx, m)
+	}
+
+	var payload UnsubscribePayload
+	if err := json.Unmarshal(m.Data, &payload); err != nil {
+		h.reject(m, invalidPayloadError(err))
+
+		return nil
 	}
 
-	t := msg.Data.Type
-	if _, err = h.conn.Events().Unsubscribe(gctx, t, msg.Data.Condition); err != nil {
+	id, err := h.unsubscribe(gctx, payload)
+	if err != nil {
 		if err == ErrNotSubscribed {
-			h.conn.SendClose(events.CloseCodeNotSubscribed, 0)
+			h.reject(m, NewError(ErrorCodeNotSubscribed, nil))
+
 			return nil
 		}
 
@@ -268,54 +332,63 @@ func (h handler) Unsubscribe(gctx global.Context, m events.Message[json.RawMessa
 	}
 
 	_ = h.conn.SendAck(events.OpcodeUnsubscribe, utils.ToJSON(struct {
-		Type      string            `json:"type"`
-		Condition map[string]string `json:"condition"`
+		ID        uint32    `json:"id,omitempty"`
+		Type      string    `json:"type"`
+		Condition Condition `json:"condition"`
 	}{
-		Type:      string(msg.Data.Type),
-		Condition: msg.Data.Condition,
-	}))
+		ID:        id,
+		Type:      string(payload.Type),
+		Condition: payload.Condition,
+	}), m.Nonce)
 
 	return nil
 }
 
-func (h handler) OnResume(gctx global.Context, m events.Message[json.RawMessage]) error {
-	/*
-		msg, err := events.ConvertMessage[events.ResumePayload](m)
-		if err != nil {
-			return err
-		}
+// unsubscribe removes the subscription with the ID of the payload,
+// or else the first one matching its type and condition
+func (h handler) unsubscribe(gctx global.Context, payload UnsubscribePayload) (uint32, error) {
+	if payload.ID != 0 {
+		return payload.ID, h.conn.Events().UnsubscribeWithID(payload.ID)
+	}
 
-			// Set up a new event buffer with the specified session ID
-			buf := NewEventBuffer(h.conn, msg.Data.SessionID, time.Minute)
+	return h.conn.Events().Unsubscribe(gctx, payload.Type, payload.Condition)
+}
 
-			messages, subs, err := buf.Recover(gctx)
-			subCount := 0
+// ResumePayload is the payload of a RESUME command
+type ResumePayload struct {
+	SessionID string `json:"session_id"`
+	// The stream sequence of the last dispatch received by the client
+	StreamSequence uint64 `json:"stream_seq"`
+}
 
-			if err == nil {
-				// Reinstate subscriptions
-				for _, s := range subs {
-					for i := range s.Channel.ID {
-						cond := s.Channel.Conditions[i]
-						props := s.Channel.Properties[i]
+func (h handler) OnResume(gctx global.Context, m ClientMessage) error {
+	var payload ResumePayload
+	if err := json.Unmarshal(m.Data, &payload); err != nil {
+		h.reject(m, invalidPayloadError(err))
 
-						_, _, err := h.conn.Events().Subscribe(gctx, h.conn.Context(), s.Type, cond, props)
-						if err != nil {
-							return err
-						}
+		return nil
+	}
 
-						subCount++
-					}
-				}
+	// Replay the dispatches missed since the client's last stream sequence,
+	// matching the subscriptions it has made on this connection.
+	// without a sequence there is nothing to recover, which is only reported by the ACK
+	replayed, success := 0, false
+	if payload.StreamSequence > 0 {
+		var err error
 
-				// Replay dispatches
-				for _, m := range messages {
-					h.OnDispatch(gctx, m)
-				}
-			} else {
-				h.conn.SendError("Resume Failed", map[string]any{
-					"error": err.Error(),
-				})
-			}*/
+		replayed, err = h.Replay(gctx, payload.StreamSequence)
+		if err != nil {
+			e := NewError(ErrorCodeResumeFailed, map[string]any{
+				"error": err.Error(),
+			})
+			e.Nonce = m.Nonce
+			e.command = OpcodeName(m.Op)
+
+			h.conn.SendError(e)
+		}
+
+		success = err == nil
+	}
 
 	// Send ACK
 	_ = h.conn.SendAck(events.OpcodeResume, utils.ToJSON(struct {
@@ -323,23 +396,84 @@ func (h handler) OnResume(gctx global.Context, m events.Message[json.RawMessage]
 		DispatchesReplayed    int  `json:"dispatches_replayed"`
 		SubscriptionsRestored int  `json:"subscriptions_restored"`
 	}{
-		Success:               false,
-		DispatchesReplayed:    0,
+		Success:               success,
+		DispatchesReplayed:    replayed,
 		SubscriptionsRestored: 0,
-	}))
+	}), m.Nonce)
+
+	return nil
+}
+
+func (h handler) OnHeartbeat(gctx global.Context, m ClientMessage) error {
+	_ = h.conn.SendAck(events.OpcodeHeartbeat, m.Data, m.Nonce)
+
+	return nil
+}
+
+// ReplayPayload is the payload of a REPLAY command
+type ReplayPayload struct {
+	// The sequence of the last message received by the client
+	After uint64 `json:"after"`
+}
 
-	/*
-		// Cleanup the redis data
-		if err = buf.Cleanup(gctx); err != nil {
-			zap.S().Errorw("failed to cleanup event buffer", "error", err)
+func (h handler) OnReplay(gctx global.Context, m ClientMessage) error {
+	var payload ReplayPayload
+	if err := json.Unmarshal(m.Data, &payload); err != nil {
+		h.reject(m, invalidPayloadError(err))
+
+		return nil
+	}
+
+	result, err := h.conn.ReplayHistory(payload.After)
+	if err != nil {
+		if err != ErrHistoryUnavailable {
+			return err
 		}
-	*/
+
+		e := NewError(ErrorCodeHistoryUnavailable, nil)
+		e.Nonce = m.Nonce
+		e.command = OpcodeName(m.Op)
+
+		h.conn.SendError(e)
+
+		return nil
+	}
+
+	_ = h.conn.SendAck(OpcodeReplay, utils.ToJSON(result), m.Nonce)
 
 	return nil
 }
 
-func (h handler) OnBridge(gctx global.Context, m events.Message[json.RawMessage]) error {
-	msg, err := events.ConvertMessage[events.BridgedCommandPayload[json.RawMessage]](m)
+func (h handler) Replay(gctx global.Context, after uint64) (int, error) {
+	broker, ok := gctx.Inst().Broker.(instance.ReplayableBroker)
+	if !ok {
+		return 0, ErrReplayUnavailable
+	}
+
+	messages, err := broker.Replay(h.conn.Context(), after, h.conn.Events().Keys()...)
+	if err != nil {
+		return 0, err
+	}
+
+	// Queue the messages behind the live dispatches so they pass through the connection's event loop
+	for i, msg := range messages {
+		select {
+		case h.conn.Events().DispatchChannel() <- msg:
+		case <-h.conn.Context().Done():
+			return i, nil
+		}
+	}
+
+	return len(messages), nil
+}
+
+func (h handler) OnBridge(gctx global.Context, m ClientMessage) (err error) {
+	ctx, span := tracing.Tracer().Start(m.Context(), "bridge", trace.WithSpanKind(trace.SpanKindClient))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	msg, err := events.ConvertMessage[events.BridgedCommandPayload[json.RawMessage]](m.Message)
 	if err != nil {
 		return err
 	}
@@ -351,13 +485,23 @@ func (h handler) OnBridge(gctx global.Context, m events.Message[json.RawMessage]
 		return err
 	}
 
-	res, err := http.DefaultClient.Post(gctx.Config().API.BridgeURL, "application/json", bytes.NewReader(b))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, gctx.Config().API.BridgeURL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	tracing.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	res, err := http.DefaultClient.Do(req)
 	if err != nil {
 		zap.S().Errorw("failed to bridge event", "error", err)
 
 		return err
 	}
 
+	defer res.Body.Close()
+
 	var messages []events.Message[events.DispatchPayload]
 
 	if err = json.NewDecoder(res.Body).Decode(&messages); err != nil {
@@ -367,7 +511,10 @@ func (h handler) OnBridge(gctx global.Context, m events.Message[json.RawMessage]
 	}
 
 	for _, m := range messages {
-		h.OnDispatch(gctx, m)
+		dm := instance.NewDispatchMessage(m)
+		dm.SpanContext = span.SpanContext()
+
+		h.OnDispatch(gctx, dm)
 	}
 
 	return nil
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/seventv/api/data/events"
+	"github.com/seventv/common/structures/v3"
+
+	"github.com/seventv/eventapi/internal/global"
+)
+
+// testConn records what the handler sends to a connection
+type testConn struct {
+	Connection
+	mode   ProtocolMode
+	evm    *EventMap
+	acks   []json.RawMessage
+	errors []*Error
+	closes []events.CloseCode
+}
+
+func newTestConn(t *testing.T, mode ProtocolMode) (global.Context, *testConn) {
+	t.Helper()
+
+	gctx, evm := newTestEventMap(t)
+	gctx.Config().API.SubscriptionLimit = 100
+
+	t.Cleanup(func() {
+		evm.Destroy(gctx)
+	})
+
+	return gctx, &testConn{mode: mode, evm: evm}
+}
+
+func (c *testConn) Context() context.Context { return context.Background() }
+func (c *testConn) Mode() ProtocolMode       { return c.mode }
+func (c *testConn) Events() *EventMap        { return c.evm }
+func (c *testConn) Actor() *structures.User  { return nil }
+func (c *testConn) SendError(e *Error)       { c.errors = append(c.errors, e) }
+
+func (c *testConn) SendAck(cmd events.Opcode, data json.RawMessage, nonce string) error {
+	c.acks = append(c.acks, data)
+
+	return nil
+}
+
+func (c *testConn) SendClose(code events.CloseCode, after time.Duration) {
+	c.closes = append(c.closes, code)
+}
+
+func command(op events.Opcode, data string) ClientMessage {
+	return ClientMessage{
+		Message: events.Message[json.RawMessage]{Op: op, Data: json.RawMessage(data)},
+		Nonce:   "nonce",
+	}
+}
+
+func TestUnsubscribeMistakes(t *testing.T) {
+	tests := []struct {
+		name string
+		mode ProtocolMode
+		data string
+		code ErrorCode
+	}{
+		{"invalid payload, strict", ProtocolModeStrict, `{"type": 1}`, ErrorCodeInvalidPayload},
+		{"invalid payload, lenient", ProtocolModeLenient, `{"type": 1}`, ErrorCodeInvalidPayload},
+		{"not subscribed, strict", ProtocolModeStrict, `{"type": "user.update"}`, ErrorCodeNotSubscribed},
+		{"not subscribed, lenient", ProtocolModeLenient, `{"type": "user.update"}`, ErrorCodeNotSubscribed},
+		{"unknown id, strict", ProtocolModeStrict, `{"id": 42}`, ErrorCodeNotSubscribed},
+		{"unknown id, lenient", ProtocolModeLenient, `{"id": 42}`, ErrorCodeNotSubscribed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gctx, conn := newTestConn(t, tt.mode)
+
+			if err := NewHandler(conn).Unsubscribe(gctx, command(events.OpcodeUnsubscribe, tt.data)); err != nil {
+				t.Fatalf("Unsubscribe() = %v, mistakes are reported to the client", err)
+			}
+
+			if len(conn.errors) != 1 || conn.errors[0].Code != tt.code {
+				t.Fatalf("sent errors %v, want one with code %d", conn.errors, tt.code)
+			}
+
+			if conn.errors[0].Nonce != "nonce" {
+				t.Errorf("error nonce = %q, want the nonce of the command", conn.errors[0].Nonce)
+			}
+
+			if len(conn.acks) != 0 {
+				t.Errorf("sent %d acks, want none", len(conn.acks))
+			}
+
+			switch tt.mode {
+			case ProtocolModeStrict:
+				if len(conn.closes) != 1 || conn.closes[0] != conn.errors[0].CloseCode() {
+					t.Errorf("closed with %v, want the close code of the error after it", conn.closes)
+				}
+			case ProtocolModeLenient:
+				if len(conn.closes) != 0 {
+					t.Errorf("closed with %v, lenient connections stay open", conn.closes)
+				}
+
+				if conn.errors[0].Request == nil {
+					t.Error("the error does not echo the command")
+				}
+			}
+		})
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	gctx, conn := newTestConn(t, ProtocolModeStrict)
+	h := NewHandler(conn)
+
+	if err, ok := h.Subscribe(gctx, command(events.OpcodeSubscribe, `{"id": 7, "type": "user.update", "condition": {"object_id": "1"}}`)); err != nil || !ok {
+		t.Fatalf("Subscribe() = %v, %t", err, ok)
+	}
+
+	if err := h.Unsubscribe(gctx, command(events.OpcodeUnsubscribe, `{"id": 7}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(conn.errors) != 0 || len(conn.closes) != 0 || len(conn.acks) != 2 {
+		t.Fatalf("sent %d acks, errors %v and closes %v, want 2 acks only", len(conn.acks), conn.errors, conn.closes)
+	}
+
+	if n := conn.evm.Count(); n != 0 {
+		t.Errorf("Count() = %d after unsubscribing, want 0", n)
+	}
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"fmt"
+	"sync"
+)
+
+// History retains the latest messages written to a connection with their sequence,
+// bounded by a number of messages and their total size, so that a client noticing a gap can recover them
+type History struct {
+	mx       *sync.Mutex
+	ring     []historyEntry
+	start    int // index of the oldest entry
+	n        int
+	bytes    int
+	maxBytes int
+}
+
+type historyEntry struct {
+	seq uint64
+	b   []byte
+}
+
+// HistoryResult is the outcome of a replay from the history of a connection
+type HistoryResult struct {
+	// The number of messages sent again
+	Replayed int `json:"replayed"`
+	// The number of messages after the requested sequence which are no longer retained or were never sent,
+	// such as dispatches dropped because the connection was too slow to receive them
+	Missing uint64 `json:"missing"`
+}
+
+var ErrHistoryUnavailable = fmt.Errorf("the connection does not retain its messages")
+
+// NewHistory creates a history retaining up to the specified number of messages and bytes
+func NewHistory(size int, maxBytes int) *History {
+	return &History{
+		mx:       &sync.Mutex{},
+		ring:     make([]historyEntry, size),
+		maxBytes: maxBytes,
+	}
+}
+
+// Push adds a message, evicting the oldest ones beyond the limits. b is copied
+func (h *History) Push(seq uint64, b []byte) {
+	if len(h.ring) == 0 || len(b) > h.maxBytes {
+		return
+	}
+
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	for h.n > 0 && (h.n == len(h.ring) || h.bytes+len(b) > h.maxBytes) {
+		h.evict()
+	}
+
+	i := (h.start + h.n) % len(h.ring)
+
+	// reuse the buffer of the evicted entry
+	h.ring[i] = historyEntry{
+		seq: seq,
+		b:   append(h.ring[i].b[:0], b...),
+	}
+	h.n++
+	h.bytes += len(b)
+}
+
+func (h *History) evict() {
+	h.bytes -= len(h.ring[h.start].b)
+	h.start = (h.start + 1) % len(h.ring)
+	h.n--
+}
+
+// Since calls fn with each retained message with a sequence after the specified one, oldest first.
+// last is the sequence of the latest message written to the connection
+func (h *History) Since(after uint64, last uint64, fn func(b []byte) error) (HistoryResult, error) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	result := HistoryResult{}
+	if last > after {
+		result.Missing = last - after
+	}
+
+	for j := 0; j < h.n; j++ {
+		e := h.ring[(h.start+j)%len(h.ring)]
+		if e.seq <= after {
+			continue
+		}
+
+		if err := fn(e.b); err != nil {
+			return result, err
+		}
+
+		result.Replayed++
+		result.Missing--
+	}
+
+	return result, nil
+}
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	msg := func(seq uint64) []byte {
+		return []byte(fmt.Sprintf(`{"seq":%d}`, seq)) // 9 bytes for the single digit sequences
+	}
+
+	tests := []struct {
+		name     string
+		size     int
+		maxBytes int
+		pushed   []uint64 // sequences pushed, gaps being dispatches that were dropped
+		after    uint64
+		want     []uint64
+		missing  uint64
+	}{
+		{"everything retained", 4, 1024, []uint64{1, 2, 3}, 0, []uint64{1, 2, 3}, 0},
+		{"after a sequence", 4, 1024, []uint64{1, 2, 3}, 1, []uint64{2, 3}, 0},
+		{"up to date", 4, 1024, []uint64{1, 2, 3}, 3, nil, 0},
+		{"ahead of the connection", 4, 1024, []uint64{1, 2, 3}, 5, nil, 0},
+		{"evicted by count", 2, 1024, []uint64{1, 2, 3, 4}, 0, []uint64{3, 4}, 2},
+		{"evicted by bytes", 4, 20, []uint64{1, 2, 3, 4}, 0, []uint64{3, 4}, 2},
+		{"wraps around", 3, 1024, []uint64{1, 2, 3, 4, 5, 6, 7}, 4, []uint64{5, 6, 7}, 0},
+		{"dropped sequences are missing", 4, 1024, []uint64{1, 4, 5}, 0, []uint64{1, 4, 5}, 2},
+		{"message larger than the byte cap", 4, 8, []uint64{1, 2}, 0, nil, 2},
+		{"disabled", 0, 1024, []uint64{1, 2}, 0, nil, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHistory(tt.size, tt.maxBytes)
+
+			var last uint64
+			for _, seq := range tt.pushed {
+				h.Push(seq, msg(seq))
+				last = seq
+			}
+
+			var got []uint64
+
+			result, err := h.Since(tt.after, last, func(b []byte) error {
+				var seq uint64
+				if _, err := fmt.Sscanf(string(b), `{"seq":%d}`, &seq); err != nil {
+					return err
+				}
+
+				got = append(got, seq)
+
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("replayed %v, want %v", got, tt.want)
+			}
+
+			if result.Replayed != len(tt.want) || result.Missing != tt.missing {
+				t.Errorf("Since() = %+v, want %d replayed and %d missing", result, len(tt.want), tt.missing)
+			}
+		})
+	}
+}
+
+func TestHistoryCopiesMessages(t *testing.T) {
+	h := NewHistory(2, 1024)
+
+	b := []byte(`{"seq":1}`)
+	h.Push(1, b)
+	copy(b, `{"seq":9}`)
+
+	_, _ = h.Since(0, 1, func(got []byte) error {
+		if string(got) != `{"seq":1}` {
+			t.Errorf("replayed %s, the history must not retain the pushed buffer", got)
+		}
+
+		return nil
+	})
+}
+
+func TestHistoryStopsOnError(t *testing.T) {
+	h := NewHistory(4, 1024)
+	for seq := uint64(1); seq <= 3; seq++ {
+		h.Push(seq, []byte(`{}`))
+	}
+
+	errWrite := errors.New("write failed")
+	calls := 0
+
+	result, err := h.Since(0, 3, func(b []byte) error {
+		calls++
+
+		return errWrite
+	})
+	if !errors.Is(err, errWrite) || calls != 1 || result.Replayed != 0 {
+		t.Errorf("Since() = %+v, %v after %d calls, want to stop at the first error", result, err, calls)
+	}
+}
@@ -0,0 +1,207 @@
+package client
+
+import (
+	"strings"
+
+	"github.com/seventv/api/data/events"
+)
+
+// subscriptionIndex resolves the subscriptions matched by a dispatch without scanning them,
+// by indexing their conditions per event type and condition key/value pair.
+//
+// Subscriptions to "<object>.*" are indexed by object name and match every event type of the object
+type subscriptionIndex struct {
+	types     map[events.EventType]*typeIndex
+	wildcards map[events.EventType]*typeIndex // object name as key
+	// incremented on every match, to dedupe subscriptions matched by several conditions
+	gen uint64
+}
+
+type typeIndex struct {
+	// all subscriptions to the type
+	all []*indexedSubscription
+	// subscriptions by the key/value pairs of their condition
+	pairs map[conditionPair][]*indexedSubscription
+	// subscriptions by the keys of their condition accepting values that can't be listed, such as prefixes
+	unindexed map[string][]*indexedSubscription
+}
+
+type conditionPair struct {
+	key   string
+	value string
+}
+
+type indexedSubscription struct {
+	id   uint32
+	cond Condition
+	gen  uint64
+}
+
+func newSubscriptionIndex() *subscriptionIndex {
+	return &subscriptionIndex{
+		types:     map[events.EventType]*typeIndex{},
+		wildcards: map[events.EventType]*typeIndex{},
+	}
+}
+
+func (x *subscriptionIndex) add(t events.EventType, id uint32, cond Condition) {
+	m, k := x.bucket(t)
+
+	ti, ok := m[k]
+	if !ok {
+		ti = &typeIndex{
+			pairs:     map[conditionPair][]*indexedSubscription{},
+			unindexed: map[string][]*indexedSubscription{},
+		}
+		m[k] = ti
+	}
+
+	sub := &indexedSubscription{
+		id:   id,
+		cond: cond,
+	}
+
+	ti.all = append(ti.all, sub)
+
+	for k, v := range cond {
+		if !v.Indexable() {
+			ti.unindexed[k] = append(ti.unindexed[k], sub)
+			continue
+		}
+
+		for _, s := range v.Values {
+			p := conditionPair{k, s}
+			ti.pairs[p] = append(ti.pairs[p], sub)
+		}
+	}
+}
+
+func (x *subscriptionIndex) remove(t events.EventType, id uint32) {
+	m, k := x.bucket(t)
+
+	ti, ok := m[k]
+	if !ok {
+		return
+	}
+
+	var sub *indexedSubscription
+
+	ti.all, sub = removeIndexed(ti.all, id)
+	if sub == nil {
+		return
+	}
+
+	for k, v := range sub.cond {
+		if !v.Indexable() {
+			if ti.unindexed[k], _ = removeIndexed(ti.unindexed[k], id); len(ti.unindexed[k]) == 0 {
+				delete(ti.unindexed, k)
+			}
+
+			continue
+		}
+
+		for _, s := range v.Values {
+			p := conditionPair{k, s}
+
+			if ti.pairs[p], _ = removeIndexed(ti.pairs[p], id); len(ti.pairs[p]) == 0 {
+				delete(ti.pairs, p)
+			}
+		}
+	}
+
+	if len(ti.all) == 0 {
+		delete(m, k)
+	}
+}
+
+// bucket returns the map and key a type is indexed under
+func (x *subscriptionIndex) bucket(t events.EventType) (map[events.EventType]*typeIndex, events.EventType) {
+	if strings.HasSuffix(string(t), ".*") {
+		return x.wildcards, events.EventType(strings.TrimSuffix(string(t), ".*"))
+	}
+
+	return x.types, t
+}
+
+// match appends the IDs of the subscriptions to the specified type that any of the conditions match to dst
+//
+// A subscription is matched by a condition if its own condition accepts each of the condition's key/value pairs
+func (x *subscriptionIndex) match(t events.EventType, conds []events.EventCondition, dst []uint32) []uint32 {
+	x.gen++
+
+	if ti, ok := x.types[t]; ok {
+		dst = ti.match(conds, x.gen, dst)
+	}
+
+	obj := string(t)
+	if i := strings.IndexByte(obj, '.'); i != -1 {
+		obj = obj[:i]
+	}
+
+	if ti, ok := x.wildcards[events.EventType(obj)]; ok {
+		dst = ti.match(conds, x.gen, dst)
+	}
+
+	return dst
+}
+
+func (ti *typeIndex) match(conds []events.EventCondition, gen uint64, dst []uint32) []uint32 {
+	for _, c := range conds {
+		// use the narrowest pair of the condition to find candidates:
+		// a subscription accepting it either lists its value or has an unindexed value for its key
+		candidates, unindexed := ti.all, []*indexedSubscription(nil)
+
+		for k, v := range c {
+			if v == "" {
+				continue // also accepted by subscriptions without the key
+			}
+
+			subs, u := ti.pairs[conditionPair{k, v}], ti.unindexed[k]
+			if len(subs)+len(u) < len(candidates)+len(unindexed) {
+				candidates, unindexed = subs, u
+			}
+
+			if len(candidates)+len(unindexed) == 0 {
+				break
+			}
+		}
+
+		dst = matchCandidates(candidates, c, gen, dst)
+		dst = matchCandidates(unindexed, c, gen, dst)
+	}
+
+	return dst
+}
+
+func matchCandidates(candidates []*indexedSubscription, c events.EventCondition, gen uint64, dst []uint32) []uint32 {
+	for _, sub := range candidates {
+		if sub.gen == gen || !sub.cond.Accepts(c) {
+			continue
+		}
+
+		sub.gen = gen
+		dst = append(dst, sub.id)
+	}
+
+	return dst
+}
+
+// removeIndexed removes the subscription with the specified ID from a list, returning it
+func removeIndexed(subs []*indexedSubscription, id uint32) ([]*indexedSubscription, *indexedSubscription) {
+	var removed *indexedSubscription
+
+	for i := len(subs) - 1; i >= 0; i-- {
+		if subs[i].id != id {
+			continue
+		}
+
+		removed = subs[i]
+
+		last := len(subs) - 1
+		subs[i] = subs[last]
+		subs[last] = nil
+		subs = subs[:last]
+	}
+
+	return subs, removed
+}
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"net/http"
+)
+
+// ProtocolMode determines how a connection handles recoverable mistakes in client commands
+type ProtocolMode string
+
+const (
+	// ProtocolModeStrict closes the connection with the close code of the mistake
+	ProtocolModeStrict ProtocolMode = "strict"
+	// ProtocolModeLenient reports the mistake with an error and keeps the connection open
+	ProtocolModeLenient ProtocolMode = "lenient"
+)
+
+// ParseProtocolMode returns the mode requested by a connection with its "mode" query parameter, strict by default
+func ParseProtocolMode(r *http.Request) ProtocolMode {
+	if ProtocolMode(r.URL.Query().Get("mode")) == ProtocolModeLenient {
+		return ProtocolModeLenient
+	}
+
+	return ProtocolModeStrict
+}
+
+// reject reports a recoverable mistake in a command
+//
+// In strict mode the connection is closed, while in lenient mode the error
+// echoes the offending command and the connection stays open
+func (h handler) reject(m ClientMessage, e *Error) {
+	e.Nonce = m.Nonce
+	e.command = OpcodeName(m.Op)
+
+	if h.conn.Mode() != ProtocolModeLenient {
+		h.conn.SendError(e)
+		h.conn.SendClose(e.CloseCode(), 0)
+
+		return
+	}
+
+	e.Request = &m.Message
+
+	h.conn.SendError(e)
+}
@@ -0,0 +1,29 @@
+package client
+
+import (
+	"github.com/seventv/api/data/events"
+)
+
+// Opcodes of the commands and messages added by the EventAPI on top of the events package
+const (
+	// OpcodeSubscriptionsChanged notifies the client of subscriptions added or removed on its behalf
+	OpcodeSubscriptionsChanged events.Opcode = 8
+	// OpcodeListSubscriptions requests the active subscriptions of the connection
+	OpcodeListSubscriptions events.Opcode = 39
+	// OpcodeReplay requests the messages sent after a sequence again
+	OpcodeReplay events.Opcode = 40
+)
+
+// OpcodeName returns the name of an opcode, including the ones defined by this package
+func OpcodeName(op events.Opcode) string {
+	switch op {
+	case OpcodeSubscriptionsChanged:
+		return "SUBSCRIPTIONS_CHANGED"
+	case OpcodeListSubscriptions:
+		return "LIST_SUBSCRIPTIONS"
+	case OpcodeReplay:
+		return "REPLAY"
+	default:
+		return op.String()
+	}
+}
@@ -0,0 +1,163 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/seventv/api/data/events"
+	"github.com/seventv/common/utils"
+	"go.uber.org/zap"
+
+	"github.com/seventv/eventapi/internal/global"
+)
+
+// SubscriptionInfo describes an active subscription of a connection
+type SubscriptionInfo struct {
+	ID        uint32           `json:"id"`
+	Type      events.EventType `json:"type"`
+	Condition Condition        `json:"condition"`
+	// Whether the subscription was added on the client's behalf by a dispatch
+	Auto bool `json:"auto"`
+	// The time the subscription is removed at in unix millis, if it is temporary
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+// ListSubscriptionsPayload is the payload of a LIST_SUBSCRIPTIONS command
+type ListSubscriptionsPayload struct {
+	// Whether to be notified of subscriptions added or removed on the client's behalf from then on
+	Watch *bool `json:"watch,omitempty"`
+}
+
+// SubscriptionsChangedPayload is the payload of a SUBSCRIPTIONS_CHANGED message
+type SubscriptionsChangedPayload struct {
+	Added   []SubscriptionInfo `json:"added"`
+	Removed []SubscriptionInfo `json:"removed"`
+}
+
+// WatchRequested checks whether a connection asked to be notified of the subscriptions
+// changed on its behalf with its "watch_subscriptions" query parameter
+func WatchRequested(r *http.Request) bool {
+	ok, _ := strconv.ParseBool(r.URL.Query().Get("watch_subscriptions"))
+
+	return ok
+}
+
+// TimestampsRequested checks whether a connection asked for the time each dispatch is sent at
+// with its "timestamps" query parameter
+func TimestampsRequested(r *http.Request) bool {
+	ok, _ := strconv.ParseBool(r.URL.Query().Get("timestamps"))
+
+	return ok
+}
+
+// List returns the active subscriptions, ordered by type
+func (e *EventMap) List() []SubscriptionInfo {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+
+	result := make([]SubscriptionInfo, 0, len(e.ids))
+
+	for t, ec := range e.m {
+		for i := range ec.ID {
+			result = append(result, ec.info(t, i))
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Type < result[j].Type
+	})
+
+	return result
+}
+
+// Get returns the subscription with the specified ID
+func (e *EventMap) Get(id uint32) (SubscriptionInfo, bool) {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+
+	t, ok := e.ids[id]
+	if !ok {
+		return SubscriptionInfo{}, false
+	}
+
+	ec := e.m[t]
+	for i, v := range ec.ID {
+		if v == id {
+			return ec.info(t, i), true
+		}
+	}
+
+	return SubscriptionInfo{}, false
+}
+
+// Watch sets whether the client is notified of the subscriptions changed on its behalf
+func (e *EventMap) Watch(enabled bool) {
+	atomic.StoreInt32(e.watch, utils.Ternary(enabled, int32(1), int32(0)))
+}
+
+// Watching checks whether the client is notified of the subscriptions changed on its behalf
+func (e *EventMap) Watching() bool {
+	return atomic.LoadInt32(e.watch) == 1
+}
+
+func (ec EventChannel) info(t events.EventType, i int) SubscriptionInfo {
+	info := SubscriptionInfo{
+		ID:        ec.ID[i],
+		Type:      t,
+		Condition: ec.Conditions[i],
+		Auto:      ec.Properties[i].Auto,
+	}
+
+	if ttl := ec.Properties[i].TTL; !ttl.IsZero() {
+		info.ExpiresAt = ttl.UnixMilli()
+	}
+
+	return info
+}
+
+// ListSubscriptions acknowledges a LIST_SUBSCRIPTIONS command with the active subscriptions of the connection
+func (h handler) ListSubscriptions(gctx global.Context, m ClientMessage) error {
+	var payload ListSubscriptionsPayload
+	if len(m.Data) > 0 && string(m.Data) != "null" {
+		if err := json.Unmarshal(m.Data, &payload); err != nil {
+			h.reject(m, invalidPayloadError(err))
+
+			return nil
+		}
+	}
+
+	if payload.Watch != nil {
+		h.conn.Events().Watch(*payload.Watch)
+	}
+
+	_ = h.conn.SendAck(OpcodeListSubscriptions, utils.ToJSON(struct {
+		Subscriptions []SubscriptionInfo `json:"subscriptions"`
+		Watch         bool               `json:"watch"`
+	}{
+		Subscriptions: h.conn.Events().List(),
+		Watch:         h.conn.Events().Watching(),
+	}), m.Nonce)
+
+	return nil
+}
+
+// notifySubscriptions lets a watching client know of the subscriptions changed on its behalf
+func (h handler) notifySubscriptions(added, removed []SubscriptionInfo) {
+	if len(added)+len(removed) == 0 || !h.conn.Events().Watching() {
+		return
+	}
+
+	msg := events.NewMessage(OpcodeSubscriptionsChanged, json.RawMessage(utils.ToJSON(SubscriptionsChangedPayload{
+		Added:   utils.Ternary(added == nil, []SubscriptionInfo{}, added),
+		Removed: utils.Ternary(removed == nil, []SubscriptionInfo{}, removed),
+	})))
+
+	if err := h.conn.Write(msg); err != nil {
+		zap.S().Errorw("failed to notify subscription changes",
+			"error", err,
+		)
+	}
+}
@@ -6,61 +6,90 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/seventv/api/data/events"
 	"github.com/seventv/common/structures/v3"
+	"github.com/seventv/common/utils"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 
 	client "github.com/seventv/eventapi/internal/app/connection"
 	"github.com/seventv/eventapi/internal/global"
+	"github.com/seventv/eventapi/internal/instance"
+	"github.com/seventv/eventapi/internal/tracing"
 )
 
 type WebSocket struct {
 	c                 *websocket.Conn
 	ctx               context.Context
+	span              trace.Span // the span of the connection, ended once it's destroyed
 	cancel            context.CancelFunc
-	seq               int64
+	seq               uint64 // sequence of the last message written, guarded by writeMtx
+	history           *client.History
+	pingInterval      time.Duration
+	pongTimeout       time.Duration
 	handler           client.Handler
 	evm               *client.EventMap
 	cache             client.Cache
 	evbuf             client.EventBuffer
 	writeMtx          *sync.Mutex
+	buf               []byte // reused for writing dispatches, guarded by writeMtx
 	ready             chan struct{}
 	readyOnce         sync.Once
 	sessionID         []byte
 	heartbeatInterval uint32
 	heartbeatCount    uint64
 	subscriptionLimit int32
+	mode              client.ProtocolMode
+	metrics           instance.EventV3
+	timestamps        bool // whether dispatches carry the time they are sent at
 }
 
-func NewWebSocket(gctx global.Context, conn *websocket.Conn) (client.Connection, error) {
-	hbi := gctx.Config().API.HeartbeatInterval
-	if hbi == 0 {
-		hbi = 45000
-	}
+func NewWebSocket(gctx global.Context, conn *websocket.Conn, r *http.Request) (client.Connection, error) {
+	cfg := gctx.Config().API
 
 	sessionID, err := client.GenerateSessionID(32)
 	if err != nil {
 		return nil, err
 	}
 
-	lctx, cancel := context.WithCancel(context.Background())
+	tctx, span := tracing.StartConnection(context.Background(), propagation.HeaderCarrier(r.Header), string(client.TransportWebSocket))
+
+	lctx, cancel := context.WithCancel(tctx)
 	ws := &WebSocket{
 		c:                 conn,
 		ctx:               lctx,
+		span:              span,
 		cancel:            cancel,
-		seq:               0,
-		evm:               client.NewEventMap(string(sessionID)),
+		evm:               client.NewEventMap(gctx, string(sessionID)),
 		cache:             client.NewCache(),
 		writeMtx:          &sync.Mutex{},
 		ready:             make(chan struct{}),
 		sessionID:         sessionID,
-		heartbeatInterval: hbi,
+		heartbeatInterval: cfg.HeartbeatInterval,
 		heartbeatCount:    0,
-		subscriptionLimit: gctx.Config().API.SubscriptionLimit,
+		subscriptionLimit: cfg.SubscriptionLimit,
+		mode:              client.ParseProtocolMode(r),
+		metrics:           gctx.Inst().Monitoring.EventV3(),
+		timestamps:        client.TimestampsRequested(r),
+	}
+
+	ws.evm.Watch(client.WatchRequested(r))
+
+	if cfg.WebSocket.PingInterval >= 0 {
+		ws.pingInterval = time.Duration(cfg.WebSocket.PingInterval) * time.Millisecond
+		ws.pongTimeout = time.Duration(cfg.WebSocket.PongTimeout) * time.Millisecond
+	}
+
+	conn.SetReadLimit(cfg.WebSocket.MaxMessageSize)
+
+	if cfg.History.Size >= 0 {
+		ws.history = client.NewHistory(cfg.History.Size, cfg.History.MaxBytes)
 	}
 
 	ws.handler = client.NewHandler(ws)
@@ -99,13 +128,20 @@ func (w *WebSocket) SendHeartbeat() error {
 	return w.Write(msg.ToRaw())
 }
 
-func (w *WebSocket) SendAck(cmd events.Opcode, data json.RawMessage) error {
-	msg := events.NewMessage(events.OpcodeAck, events.AckPayload{
-		Command: cmd.String(),
+func (w *WebSocket) SendAck(cmd events.Opcode, data json.RawMessage, nonce string) error {
+	msg := events.NewMessage(events.OpcodeAck, json.RawMessage(utils.ToJSON(client.AckPayload{
+		Command: client.OpcodeName(cmd),
 		Data:    data,
-	})
+		Nonce:   nonce,
+	})))
 
-	return w.Write(msg.ToRaw())
+	if err := w.Write(msg); err != nil {
+		return err
+	}
+
+	w.metrics.Acks.WithLabelValues(client.OpcodeName(cmd)).Inc()
+
+	return nil
 }
 
 func (w *WebSocket) SendClose(code events.CloseCode, after time.Duration) {
@@ -115,6 +151,8 @@ func (w *WebSocket) SendClose(code events.CloseCode, after time.Duration) {
 
 	defer w.ForceClose()
 
+	w.metrics.Closes.WithLabelValues(string(w.Transport()), code.String()).Inc()
+
 	// Send "end of stream" message
 	msg := events.NewMessage(events.OpcodeEndOfStream, events.EndOfStreamPayload{
 		Code:    code,
@@ -138,6 +176,18 @@ func (w *WebSocket) SendClose(code events.CloseCode, after time.Duration) {
 	}
 }
 
+// extendReadDeadline gives the client until the next ping has gone unanswered to show it is alive
+func (w *WebSocket) extendReadDeadline() {
+	if w.pingInterval > 0 {
+		_ = w.c.SetReadDeadline(time.Now().Add(w.pingInterval + w.pongTimeout))
+	}
+}
+
+// SendPing sends a protocol-level ping, which the client must answer before the read deadline
+func (w *WebSocket) SendPing() error {
+	return w.c.WriteControl(websocket.PingMessage, nil, time.Now().Add(w.pongTimeout))
+}
+
 func (w *WebSocket) ForceClose() {
 	_ = w.c.Close()
 	w.cancel()
@@ -148,16 +198,89 @@ func (w *WebSocket) Write(msg events.Message[json.RawMessage]) error {
 		return nil
 	}
 
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
 	w.writeMtx.Lock()
 	defer w.writeMtx.Unlock()
 
-	if err := w.c.WriteJSON(msg); err != nil {
+	_, err = w.writeMessage(b)
+
+	return err
+}
+
+// WriteDispatch implements client.Connection
+func (w *WebSocket) WriteDispatch(msg *instance.BrokerMessage, matches []uint32) error {
+	if w.ctx.Err() != nil {
+		return nil
+	}
+
+	frame, err := client.NewDispatchFrame(msg, client.TransportWebSocket, func(m events.Message[json.RawMessage]) ([]byte, error) {
+		return json.Marshal(client.DispatchMessage{
+			Message:        m,
+			StreamSequence: msg.Sequence,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	w.writeMtx.Lock()
+	defer w.writeMtx.Unlock()
+
+	start := time.Now()
+
+	w.buf, err = w.writeMessage(frame.Append(w.buf[:0], matches, utils.Ternary(w.timestamps, start.UnixMilli(), 0)))
+	if err != nil {
 		return err
 	}
 
+	w.metrics.WriteLatency.WithLabelValues(string(w.Transport())).Observe(time.Since(start).Seconds())
+	w.metrics.Dispatches.WithLabelValues(string(msg.Dispatch.Data.Type), string(w.Transport())).Inc()
+
 	return nil
 }
 
+// writeMessage stamps an encoded message with the next sequence of the connection,
+// then writes it and retains it in the history. writeMtx must be held
+//
+// The sequences of the dispatches dropped since the previous message are skipped, letting the client notice the gap
+func (w *WebSocket) writeMessage(b []byte) ([]byte, error) {
+	w.seq += w.evm.Dropped() + 1
+
+	b = append(b[:len(b)-1], `,"seq":`...)
+	b = strconv.AppendUint(b, w.seq, 10)
+	b = append(b, '}')
+
+	if err := w.c.WriteMessage(websocket.TextMessage, b); err != nil {
+		return b, err
+	}
+
+	w.metrics.BytesWritten.WithLabelValues(string(w.Transport())).Add(float64(len(b)))
+
+	if w.history != nil {
+		w.history.Push(w.seq, b)
+	}
+
+	return b, nil
+}
+
+// ReplayHistory implements client.Connection
+func (w *WebSocket) ReplayHistory(after uint64) (client.HistoryResult, error) {
+	if w.history == nil {
+		return client.HistoryResult{}, client.ErrHistoryUnavailable
+	}
+
+	w.writeMtx.Lock()
+	defer w.writeMtx.Unlock()
+
+	return w.history.Since(after, w.seq, func(b []byte) error {
+		return w.c.WriteMessage(websocket.TextMessage, b)
+	})
+}
+
 func (w *WebSocket) Events() *client.EventMap {
 	return w.evm
 }
@@ -181,17 +304,12 @@ func (w *WebSocket) Handler() client.Handler {
 }
 
 // SendError implements Connection
-func (w *WebSocket) SendError(txt string, fields map[string]any) {
-	if fields == nil {
-		fields = map[string]any{}
-	}
+func (w *WebSocket) SendError(e *client.Error) {
+	w.metrics.Errors.WithLabelValues(e.Command(), strconv.Itoa(int(e.Code))).Inc()
 
-	msg := events.NewMessage(events.OpcodeError, events.ErrorPayload{
-		Message: txt,
-		Fields:  fields,
-	})
+	msg := events.NewMessage(events.OpcodeError, json.RawMessage(utils.ToJSON(e)))
 
-	if err := w.Write(msg.ToRaw()); err != nil {
+	if err := w.Write(msg); err != nil {
 		zap.S().Errorw("failed to write an error message to the socket", "error", err)
 	}
 }
@@ -218,8 +336,14 @@ func (w *WebSocket) Destroy(gctx global.Context) {
 	w.ForceClose()
 	w.SetReady()
 	w.evm.Destroy(gctx)
+	w.span.End()
 }
 
 func (w *WebSocket) Transport() client.Transport {
 	return client.TransportWebSocket
 }
+
+// Mode implements client.Connection
+func (w *WebSocket) Mode() client.ProtocolMode {
+	return w.mode
+}
@@ -1,7 +1,9 @@
 package websocket
 
 import (
-	"encoding/json"
+	"errors"
+	"math/rand"
+	"net"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -11,6 +13,7 @@ import (
 
 	client "github.com/seventv/eventapi/internal/app/connection"
 	"github.com/seventv/eventapi/internal/global"
+	"github.com/seventv/eventapi/internal/tracing"
 )
 
 var ResumableCloseCodes = []int{
@@ -21,10 +24,36 @@ var ResumableCloseCodes = []int{
 	int(events.OpcodeReconnect),
 }
 
+// connectionTTL returns the time limit of a connection, randomized so that
+// the connections opened at the same time aren't told to reconnect at the same time
+func connectionTTL(gctx global.Context) time.Duration {
+	ttl := time.Duration(gctx.Config().API.TTL) * time.Minute
+
+	jitter := time.Duration(gctx.Config().API.TTLJitter) * time.Millisecond
+	if jitter > 0 {
+		ttl += time.Duration(rand.Int63n(int64(jitter)))
+	}
+
+	return ttl
+}
+
 func (w *WebSocket) Read(gctx global.Context) {
 	heartbeat := time.NewTicker(time.Duration(w.heartbeatInterval) * time.Millisecond)
 
-	ttl := time.NewTimer(time.Duration(gctx.Config().API.TTL) * time.Minute)
+	ttl := time.NewTimer(connectionTTL(gctx))
+
+	// closed once the pod lets the connection be told to reconnect
+	reconnect := make(chan struct{})
+
+	// protocol-level pings let half-open connections be noticed through the read deadline
+	var ping <-chan time.Time
+
+	if w.pingInterval > 0 {
+		t := time.NewTicker(w.pingInterval)
+		defer t.Stop()
+
+		ping = t.C
+	}
 
 	deferred := false
 
@@ -43,9 +72,9 @@ func (w *WebSocket) Read(gctx global.Context) {
 		}
 
 		// Subscribe to whispers
-		if _, _, err := w.evm.Subscribe(gctx, w.ctx, events.EventTypeWhisper, events.EventCondition{
+		if _, _, err := w.evm.Subscribe(gctx, w.ctx, events.EventTypeWhisper, client.NewCondition(events.EventCondition{
 			"session_id": w.SessionID(),
-		}, client.EventSubscriptionProperties{
+		}), client.EventSubscriptionProperties{
 			Auto: true,
 		}); err != nil {
 			zap.S().Errorw("whisper subscription error", "error", err, "session_id", w.SessionID())
@@ -64,11 +93,21 @@ func (w *WebSocket) Read(gctx global.Context) {
 
 		throttle := utils.NewThrottle(time.Millisecond * 100)
 
-		var msg events.Message[json.RawMessage]
+		// any message or pong from the client shows that it is alive
+		w.extendReadDeadline()
+		w.c.SetPongHandler(func(string) error {
+			w.extendReadDeadline()
+
+			return nil
+		})
+
+		var msg client.ClientMessage
 		var err error
 
 		// Listen for incoming messages sent by the client
 		for {
+			msg = client.ClientMessage{}
+
 			err = w.c.ReadJSON(&msg)
 			if websocket.IsCloseError(err, ResumableCloseCodes...) {
 				// w.evbuf = client.NewEventBuffer(w, w.SessionID(), time.Duration(w.heartbeatInterval)*time.Millisecond)
@@ -84,43 +123,75 @@ func (w *WebSocket) Read(gctx global.Context) {
 				return
 			}
 
+			// The client stopped responding: the connection is half-open
+			var nerr net.Error
+			if errors.As(err, &nerr) && nerr.Timeout() {
+				w.metrics.ReapedConnections.WithLabelValues(string(w.Transport())).Inc()
+
+				return
+			}
+
 			if err != nil {
-				w.SendError(err.Error(), nil)
+				w.SendError(client.NewError(client.ErrorCodeInvalidMessage, map[string]any{
+					"error": err.Error(),
+				}))
 				w.SendClose(events.CloseCodeInvalidPayload, 0)
 				return
 			}
 
+			w.extendReadDeadline()
+
 			// Verify the opcode
 			if !client.IsClientSentOp(msg.Op) {
 				w.SendClose(events.CloseCodeUnknownOperation, 0)
 				return
 			}
 
+			if len(msg.Nonce) > client.COMMAND_NONCE_MAX_LENGTH {
+				w.SendError(client.NewError(client.ErrorCodeInvalidMessage, map[string]any{
+					"nonce_length":      len(msg.Nonce),
+					"nonce_length_most": client.COMMAND_NONCE_MAX_LENGTH,
+				}))
+				w.SendClose(events.CloseCodeInvalidPayload, 0)
+				return
+			}
+
+			ctx, span := tracing.Tracer().Start(w.ctx, "command "+client.OpcodeName(msg.Op))
+			msg = msg.WithContext(ctx)
+
 			handler := client.NewHandler(w)
 			switch msg.Op {
+			// Handle command - HEARTBEAT
+			case events.OpcodeHeartbeat:
+				err = handler.OnHeartbeat(gctx, msg)
 			// Handle command - RESUME
 			case events.OpcodeResume:
-				if err = handler.OnResume(gctx, msg); err != nil {
-					return
-				}
+				err = handler.OnResume(gctx, msg)
 			// Handle command - SUBSCRIBE
 			case events.OpcodeSubscribe:
-				if err, _ = handler.Subscribe(gctx, msg); err != nil {
-					return
-				}
+				err, _ = handler.Subscribe(gctx, msg)
 			// Handle command - UNSUBSCRIBE
 			case events.OpcodeUnsubscribe:
-				if err = handler.Unsubscribe(gctx, msg); err != nil {
-					return
-				}
+				err = handler.Unsubscribe(gctx, msg)
+			// Handle command - LIST_SUBSCRIPTIONS
+			case client.OpcodeListSubscriptions:
+				err = handler.ListSubscriptions(gctx, msg)
+			// Handle command - REPLAY
+			case client.OpcodeReplay:
+				err = handler.OnReplay(gctx, msg)
 			// Handle command - BRIDGE
 			case events.OpcodeBridge:
+				// a failed bridge doesn't close the connection
 				throttle.Do(func() {
-					if err = handler.OnBridge(gctx, msg); err != nil {
-						return
-					}
+					_ = handler.OnBridge(gctx, msg)
 				})
 			}
+
+			tracing.End(span, err)
+
+			if err != nil {
+				return
+			}
 		}
 	}()
 
@@ -134,19 +205,26 @@ func (w *WebSocket) Read(gctx global.Context) {
 		select {
 		case <-w.OnClose():
 			return
-		case <-gctx.Done():
-			w.SendClose(events.CloseCodeRestart, time.Second*5)
-			return
 		case <-ttl.C:
+			go func() {
+				if gctx.Inst().Reconnects.Wait(w.ctx) == nil {
+					close(reconnect)
+				}
+			}()
+		case <-reconnect:
 			_ = w.Write(events.NewMessage(events.OpcodeReconnect, events.ReconnectPayload{
 				Reason: "The server requested a reconnect",
 			}).ToRaw())
 			w.SendClose(events.CloseCodeReconnect, 0)
 
 			return
+		case <-ping:
+			if err := w.SendPing(); err != nil {
+				return
+			}
 		case <-heartbeat.C: // Send a heartbeat
 			if !deferred {
-				gctx.Inst().Monitoring.EventV3().Heartbeats.Observe(1)
+				gctx.Inst().Monitoring.EventV3().Heartbeats.WithLabelValues(string(w.Transport())).Inc()
 
 				if err := w.SendHeartbeat(); err != nil {
 					return
@@ -158,21 +236,12 @@ func (w *WebSocket) Read(gctx global.Context) {
 				return
 			}
 
-			var msg events.Message[events.DispatchPayload]
-
-			err := json.Unmarshal(s, &msg)
-			if err != nil {
-				zap.S().Errorw("dispatch unmarshal error",
-					"error", err,
-					"data", s,
-				)
-				continue
+			if !s.QueuedAt.IsZero() {
+				w.metrics.QueueLatency.WithLabelValues(string(w.Transport())).Observe(time.Since(s.QueuedAt).Seconds())
 			}
 
 			// Dispatch the event to the client
-			w.handler.OnDispatch(gctx, msg)
-
-			gctx.Inst().Monitoring.EventV3().Dispatches.Observe(1)
+			w.handler.OnDispatch(gctx, s)
 		}
 	}
 }
@@ -0,0 +1,236 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/seventv/api/data/events"
+	"github.com/seventv/common/utils"
+	"go.uber.org/zap"
+
+	client "github.com/seventv/eventapi/internal/app/connection"
+	"github.com/seventv/eventapi/internal/global"
+)
+
+// DrainOptions configures how the connections of the server are drained
+type DrainOptions struct {
+	// Time between failing readiness and closing the first connection,
+	// letting the load balancer stop sending new connections
+	ReadinessDelay time.Duration
+	// Time the drain may take, after which the remaining connections are closed at once
+	Duration time.Duration
+	// Connections closed per second, only limited by the pod's reconnect rate if 0
+	Rate int
+	// The code connections are closed with
+	Code events.CloseCode
+	// A less loaded pod suggested to the clients in the reconnect message
+	Hint string
+}
+
+// drain is a drain of the server's connections, which each wait for their turn to be closed
+type drain struct {
+	opts     DrainOptions
+	start    time.Time
+	deadline time.Time
+	// closed once the readiness delay has passed
+	begin chan struct{}
+	// paces the connections when the drain has its own rate
+	scheduler *ReconnectScheduler
+}
+
+// DrainStatus describes an ongoing drain
+type DrainStatus struct {
+	StartedAt   time.Time `json:"started_at"`
+	Deadline    time.Time `json:"deadline"`
+	Connections int32     `json:"connections"`
+	Rate        int       `json:"rate"`
+	Hint        string    `json:"hint,omitempty"`
+}
+
+// DefaultDrainOptions returns the configured drain options for closing connections with the specified code
+func DefaultDrainOptions(gctx global.Context, code events.CloseCode) DrainOptions {
+	cfg := gctx.Config().API.Drain
+
+	return DrainOptions{
+		ReadinessDelay: time.Duration(cfg.ReadinessDelay) * time.Millisecond,
+		Duration:       time.Duration(cfg.Duration) * time.Millisecond,
+		Rate:           cfg.Rate,
+		Code:           code,
+		Hint:           cfg.Hint,
+	}
+}
+
+// Drain stops accepting connections, failing readiness, then tells the clients to reconnect elsewhere.
+// It returns false if the server is already draining, in which case the ongoing drain carries on
+func (s *Server) Drain(opts DrainOptions) bool {
+	s.drainMtx.Lock()
+	defer s.drainMtx.Unlock()
+
+	if s.drain != nil {
+		return false
+	}
+
+	d := &drain{
+		opts:     opts,
+		start:    time.Now(),
+		deadline: time.Now().Add(opts.ReadinessDelay + opts.Duration),
+		begin:    make(chan struct{}),
+	}
+
+	if opts.Rate > 0 {
+		stop := make(chan struct{})
+		time.AfterFunc(time.Until(d.deadline), func() {
+			close(stop)
+		})
+
+		d.scheduler = newReconnectScheduler(opts.Rate, stop)
+	}
+
+	time.AfterFunc(opts.ReadinessDelay, func() {
+		close(d.begin)
+	})
+
+	zap.S().Infow("draining connections",
+		"connections", atomic.LoadInt32(s.activeConns),
+		"readiness_delay", opts.ReadinessDelay,
+		"duration", opts.Duration,
+		"rate", opts.Rate,
+		"hint", opts.Hint,
+	)
+
+	s.drain = d
+	atomic.StoreInt32(s.locked, 1)
+	close(s.draining)
+
+	return true
+}
+
+// Draining checks whether the server is draining its connections
+func (s *Server) Draining() bool {
+	return atomic.LoadInt32(s.locked) == 1
+}
+
+// DrainStatus returns the ongoing drain, if any
+func (s *Server) DrainStatus() (DrainStatus, bool) {
+	s.drainMtx.Lock()
+	defer s.drainMtx.Unlock()
+
+	if s.drain == nil {
+		return DrainStatus{}, false
+	}
+
+	return DrainStatus{
+		StartedAt:   s.drain.start,
+		Deadline:    s.drain.deadline,
+		Connections: atomic.LoadInt32(s.activeConns),
+		Rate:        s.drain.opts.Rate,
+		Hint:        s.drain.opts.Hint,
+	}, true
+}
+
+// waitDrained blocks until the connections are closed or the drain deadline has passed
+func (s *Server) waitDrained() {
+	s.drainMtx.Lock()
+	deadline := s.drain.deadline
+	s.drainMtx.Unlock()
+
+	ticker := time.NewTicker(time.Millisecond * 100)
+	defer ticker.Stop()
+
+	for atomic.LoadInt32(s.activeConns) > 0 && time.Now().Before(deadline) {
+		<-ticker.C
+	}
+}
+
+// release closes a connection once it's its turn in the drain,
+// or when the drain's deadline passes
+func (s *Server) release(gctx global.Context, con client.Connection) {
+	s.drainMtx.Lock()
+	d := s.drain
+	s.drainMtx.Unlock()
+
+	ctx, cancel := context.WithDeadline(con.Context(), d.deadline)
+	defer cancel()
+
+	select {
+	case <-d.begin:
+	case <-ctx.Done():
+	}
+
+	if d.scheduler != nil && ctx.Err() == nil {
+		_ = d.scheduler.Wait(ctx)
+	}
+
+	if ctx.Err() == nil {
+		_ = gctx.Inst().Reconnects.Wait(ctx)
+	}
+
+	if con.Context().Err() != nil {
+		return
+	}
+
+	if d.opts.Code == events.CloseCodeReconnect {
+		_ = con.Write(events.NewMessage(events.OpcodeReconnect, json.RawMessage(utils.ToJSON(client.ReconnectPayload{
+			Reason: "The server is draining its connections",
+			Hint:   d.opts.Hint,
+		}))))
+	}
+
+	con.SendClose(d.opts.Code, 0)
+}
+
+// maxDrainRate is the highest rate a drain can be requested with, as its scheduler buffers a second of tokens
+const maxDrainRate = 10000
+
+// drainRequest is the body of a drain request, overriding the configured options
+type drainRequest struct {
+	ReadinessDelay *int   `json:"readiness_delay"`
+	Duration       *int   `json:"duration"`
+	Rate           *int   `json:"rate"`
+	Hint           string `json:"hint"`
+}
+
+// HandleDrain starts a drain requested by an administrator
+func (s *Server) HandleDrain(w http.ResponseWriter, r *http.Request) {
+	opts := DefaultDrainOptions(s.gctx, events.CloseCodeReconnect)
+
+	var body drainRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(http.StatusBadRequest, err, w)
+			return
+		}
+	}
+
+	if body.ReadinessDelay != nil {
+		opts.ReadinessDelay = time.Duration(*body.ReadinessDelay) * time.Millisecond
+	}
+	if body.Duration != nil {
+		opts.Duration = time.Duration(*body.Duration) * time.Millisecond
+	}
+	if body.Rate != nil {
+		if *body.Rate < 0 || *body.Rate > maxDrainRate {
+			writeError(http.StatusBadRequest, fmt.Errorf("rate must be between 0 and %d", maxDrainRate), w)
+			return
+		}
+
+		opts.Rate = *body.Rate
+	}
+	if body.Hint != "" {
+		opts.Hint = body.Hint
+	}
+
+	code := http.StatusAccepted
+	if !s.Drain(opts) {
+		code = http.StatusConflict
+	}
+
+	status, _ := s.DrainStatus()
+
+	w.Header().Set("Content-Type", "application/json")
+	writeBytesResponse(code, utils.ToJSON(status), w)
+}
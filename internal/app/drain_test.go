@@ -0,0 +1,174 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/seventv/api/data/events"
+
+	client "github.com/seventv/eventapi/internal/app/connection"
+	"github.com/seventv/eventapi/internal/configure"
+	"github.com/seventv/eventapi/internal/global"
+)
+
+// drainConn records how a connection was released by a drain
+type drainConn struct {
+	client.Connection
+	ctx       context.Context
+	reconnect client.ReconnectPayload
+	code      events.CloseCode
+	closedAt  time.Time
+}
+
+func (c *drainConn) Context() context.Context { return c.ctx }
+
+func (c *drainConn) Write(msg events.Message[json.RawMessage]) error {
+	if msg.Op == events.OpcodeReconnect {
+		return json.Unmarshal(msg.Data, &c.reconnect)
+	}
+
+	return nil
+}
+
+func (c *drainConn) SendClose(code events.CloseCode, after time.Duration) {
+	c.code = code
+	c.closedAt = time.Now()
+}
+
+func newDrainServer() (global.Context, *Server) {
+	gctx := global.New(context.Background(), &configure.Config{})
+	gctx.Inst().Reconnects = unlimitedReconnects{}
+
+	return gctx, &Server{
+		gctx:        gctx,
+		locked:      new(int32),
+		draining:    make(chan struct{}),
+		drainMtx:    &sync.Mutex{},
+		activeConns: new(int32),
+	}
+}
+
+// releaseAll releases the connections concurrently, returning once they are all released
+func releaseAll(gctx global.Context, s *Server, n int) []*drainConn {
+	conns := make([]*drainConn, n)
+	wg := sync.WaitGroup{}
+
+	for i := range conns {
+		conns[i] = &drainConn{ctx: context.Background()}
+
+		wg.Add(1)
+
+		go func(c *drainConn) {
+			defer wg.Done()
+
+			s.release(gctx, c)
+		}(conns[i])
+	}
+
+	wg.Wait()
+
+	return conns
+}
+
+func TestDrainPacesConnections(t *testing.T) {
+	gctx, s := newDrainServer()
+
+	start := time.Now()
+
+	if !s.Drain(DrainOptions{
+		ReadinessDelay: 50 * time.Millisecond,
+		Duration:       5 * time.Second,
+		Rate:           20,
+		Code:           events.CloseCodeReconnect,
+		Hint:           "other-pod",
+	}) {
+		t.Fatal("Drain() = false on a server which was not draining")
+	}
+
+	if !s.Draining() {
+		t.Error("Draining() = false once the drain started")
+	}
+
+	if s.Drain(DrainOptions{}) {
+		t.Error("Drain() = true while already draining")
+	}
+
+	if status, ok := s.DrainStatus(); !ok || status.Rate != 20 || status.Hint != "other-pod" {
+		t.Errorf("DrainStatus() = %+v, %t, want the options of the first drain", status, ok)
+	}
+
+	conns := releaseAll(gctx, s, 5)
+
+	first, last := conns[0].closedAt, conns[0].closedAt
+
+	for _, c := range conns {
+		if c.code != events.CloseCodeReconnect || c.reconnect.Hint != "other-pod" {
+			t.Errorf("closed with %d after a reconnect hinting %q", c.code, c.reconnect.Hint)
+		}
+
+		if c.closedAt.Before(first) {
+			first = c.closedAt
+		}
+
+		if c.closedAt.After(last) {
+			last = c.closedAt
+		}
+	}
+
+	if first.Sub(start) < 50*time.Millisecond {
+		t.Errorf("the first connection was closed after %s, before the readiness delay", first.Sub(start))
+	}
+
+	// a token every 50ms, the first being issued during the readiness delay
+	if last.Sub(first) < 150*time.Millisecond {
+		t.Errorf("5 connections closed within %s at a rate of 20 per second", last.Sub(first))
+	}
+}
+
+func TestDrainDeadline(t *testing.T) {
+	gctx, s := newDrainServer()
+
+	start := time.Now()
+
+	// a single token per second, which the deadline passes before
+	s.Drain(DrainOptions{
+		Duration: 100 * time.Millisecond,
+		Rate:     1,
+		Code:     events.CloseCodeRestart,
+	})
+
+	conns := releaseAll(gctx, s, 3)
+
+	if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+		t.Errorf("the connections were released after %s, past the deadline", elapsed)
+	}
+
+	for _, c := range conns {
+		if c.code != events.CloseCodeRestart {
+			t.Errorf("closed with %d, want %d", c.code, events.CloseCodeRestart)
+		}
+
+		if c.reconnect != (client.ReconnectPayload{}) {
+			t.Errorf("sent a reconnect %+v when closing with another code", c.reconnect)
+		}
+	}
+}
+
+func TestDrainSkipsClosedConnections(t *testing.T) {
+	gctx, s := newDrainServer()
+
+	s.Drain(DrainOptions{Duration: time.Second, Code: events.CloseCodeReconnect})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	c := &drainConn{ctx: ctx}
+	s.release(gctx, c)
+
+	if !c.closedAt.IsZero() {
+		t.Error("a connection which already ended was closed by the drain")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	client_eventstream "github.com/seventv/eventapi/internal/app/connection/eventstream"
 	client_websocket "github.com/seventv/eventapi/internal/app/connection/websocket"
 	v3 "github.com/seventv/eventapi/internal/app/v3"
+	"github.com/seventv/eventapi/internal/httpserver"
 )
 
 func writeBytesResponse(code int, res []byte, w http.ResponseWriter) {
@@ -45,12 +46,14 @@ func (s *Server) handleV3(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		con, err = client_websocket.NewWebSocket(s.gctx, c)
+		con, err = client_websocket.NewWebSocket(s.gctx, c, r)
 		if err != nil {
 			writeError(http.StatusBadRequest, err, w)
 			return
 		}
 
+		httpserver.SetConnection(r.Context(), string(con.Transport()), con.SessionID())
+
 		err = v3.WebSocket(s.gctx, con)
 		if err != nil {
 			writeError(http.StatusBadRequest, err, w)
@@ -66,6 +69,8 @@ func (s *Server) handleV3(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		httpserver.SetConnection(r.Context(), string(con.Transport()), con.SessionID())
+
 		client_eventstream.SetEventStreamHeaders(w)
 
 		go s.TrackConnection(s.gctx, r, con)
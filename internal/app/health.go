@@ -2,8 +2,29 @@ package app
 
 import (
 	"net/http"
+
+	"github.com/seventv/common/utils"
+
+	"github.com/seventv/eventapi/internal/instance"
 )
 
+// HealthReport is the body of the /health response
+type HealthReport struct {
+	OK       bool                  `json:"ok"`
+	Draining bool                  `json:"draining"`
+	Broker   instance.BrokerStatus `json:"broker"`
+}
+
+// HandleHealth reports whether the server is able to serve new clients,
+// failing while it is draining or disconnected from the message broker
 func (s *Server) HandleHealth(w http.ResponseWriter, r *http.Request) {
-	writeBytesResponse(http.StatusOK, []byte("OK"), w)
+	report := HealthReport{
+		Draining: s.Draining(),
+		Broker:   s.gctx.Inst().Broker.Status(),
+	}
+	report.OK = !report.Draining && report.Broker.Connected
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	writeBytesResponse(utils.Ternary(report.OK, http.StatusOK, http.StatusServiceUnavailable), utils.ToJSON(report), w)
 }
@@ -0,0 +1,62 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/seventv/eventapi/internal/configure"
+	"github.com/seventv/eventapi/internal/global"
+	"github.com/seventv/eventapi/internal/instance"
+)
+
+type statusBroker struct {
+	instance.Broker
+	status instance.BrokerStatus
+}
+
+func (b statusBroker) Status() instance.BrokerStatus { return b.status }
+
+func TestHandleHealth(t *testing.T) {
+	tests := []struct {
+		name      string
+		draining  bool
+		connected bool
+		want      int
+	}{
+		{"healthy", false, true, http.StatusOK},
+		{"draining", true, true, http.StatusServiceUnavailable},
+		{"broker down", false, false, http.StatusServiceUnavailable},
+		{"draining with the broker down", true, false, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gctx := global.New(context.Background(), &configure.Config{})
+			gctx.Inst().Broker = statusBroker{status: instance.BrokerStatus{Connected: tt.connected}}
+
+			s := &Server{gctx: gctx, locked: new(int32)}
+			if tt.draining {
+				*s.locked = 1
+			}
+
+			w := httptest.NewRecorder()
+			s.HandleHealth(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+
+			var report HealthReport
+			if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+				t.Fatal(err)
+			}
+
+			if report.OK != (tt.want == http.StatusOK) || report.Draining != tt.draining || report.Broker.Connected != tt.connected {
+				t.Errorf("report = %+v", report)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/seventv/eventapi/internal/global"
+	"github.com/seventv/eventapi/internal/instance"
+)
+
+// ReconnectScheduler lets a limited number of connections per second be told to reconnect
+type ReconnectScheduler struct {
+	tokens chan struct{}
+}
+
+// NewReconnectScheduler creates a scheduler with the configured rate, not limiting reconnects unless it is positive
+//
+// Tokens keep being issued after the global context is done, since that is when the pod drains its connections
+func NewReconnectScheduler(gctx global.Context) instance.Reconnects {
+	rate := gctx.Config().API.ReconnectRate
+	if rate <= 0 {
+		return unlimitedReconnects{}
+	}
+
+	return newReconnectScheduler(rate, nil)
+}
+
+// newReconnectScheduler creates a scheduler issuing rate tokens per second until stop is closed, at least one
+func newReconnectScheduler(rate int, stop <-chan struct{}) *ReconnectScheduler {
+	if rate < 1 {
+		rate = 1
+	}
+
+	s := &ReconnectScheduler{
+		tokens: make(chan struct{}, rate),
+	}
+
+	// issue tokens in batches at high rates to keep the ticker reasonable
+	ticks := rate
+	if ticks > 100 {
+		ticks = 100
+	}
+
+	go s.refill(ticks, rate, stop)
+
+	return s
+}
+
+// refill issues rate tokens over the specified number of ticks per second,
+// spreading the tokens that don't divide evenly across the ticks
+func (s *ReconnectScheduler) refill(ticks int, rate int, stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second / time.Duration(ticks))
+	defer ticker.Stop()
+
+	for n := 1; ; n++ {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		batch := refillBatch(n, ticks, rate)
+		if n == ticks {
+			n = 0
+		}
+
+		for i := 0; i < batch; i++ {
+			select {
+			case s.tokens <- struct{}{}:
+			default: // full
+			}
+		}
+	}
+}
+
+// refillBatch returns the tokens issued on the n-th of the ticks of a second:
+// those due by this tick, minus those due by the previous one
+func refillBatch(n int, ticks int, rate int) int {
+	return n*rate/ticks - (n-1)*rate/ticks
+}
+
+// Wait implements instance.Reconnects
+func (s *ReconnectScheduler) Wait(ctx context.Context) error {
+	select {
+	case <-s.tokens:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type unlimitedReconnects struct{}
+
+func (unlimitedReconnects) Wait(ctx context.Context) error {
+	return ctx.Err()
+}
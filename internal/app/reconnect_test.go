@@ -0,0 +1,113 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/seventv/eventapi/internal/configure"
+	"github.com/seventv/eventapi/internal/global"
+)
+
+func TestRefillBatch(t *testing.T) {
+	for _, rate := range []int{1, 3, 7, 99, 100, 101, 150, 333, 999, 1001, 10000} {
+		ticks := rate
+		if ticks > 100 {
+			ticks = 100
+		}
+
+		total, most := 0, 0
+
+		for n := 1; n <= ticks; n++ {
+			batch := refillBatch(n, ticks, rate)
+			total += batch
+
+			if batch > most {
+				most = batch
+			}
+		}
+
+		if total != rate {
+			t.Errorf("rate %d: released %d tokens per second", rate, total)
+		}
+
+		// the remainder is spread, no tick issues more than one token above the others
+		if ceil := (rate + ticks - 1) / ticks; most > ceil {
+			t.Errorf("rate %d: a tick released %d tokens, want at most %d", rate, most, ceil)
+		}
+	}
+}
+
+func TestNewReconnectSchedulerUnlimited(t *testing.T) {
+	for _, rate := range []int{0, -1, -100} {
+		cfg := &configure.Config{}
+		cfg.API.ReconnectRate = rate
+
+		s := NewReconnectScheduler(global.New(context.Background(), cfg))
+		if _, ok := s.(unlimitedReconnects); !ok {
+			t.Fatalf("rate %d: got a %T, want reconnects to be unlimited", rate, s)
+		}
+
+		if err := s.Wait(context.Background()); err != nil {
+			t.Errorf("rate %d: Wait() = %v", rate, err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if err := s.Wait(ctx); err != context.Canceled {
+			t.Errorf("rate %d: Wait() = %v with a canceled context, want %v", rate, err, context.Canceled)
+		}
+	}
+}
+
+func TestReconnectSchedulerMinimumRate(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	for _, rate := range []int{0, -5} {
+		if s := newReconnectScheduler(rate, stop); cap(s.tokens) != 1 {
+			t.Errorf("rate %d: buffers %d tokens, want a rate of 1", rate, cap(s.tokens))
+		}
+	}
+}
+
+func TestReconnectSchedulerPacing(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	s := newReconnectScheduler(50, stop)
+
+	start := time.Now()
+
+	for i := 0; i < 5; i++ {
+		if err := s.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a token every 20ms
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("5 tokens issued after %s at a rate of 50 per second", elapsed)
+	}
+}
+
+func TestReconnectSchedulerStop(t *testing.T) {
+	stop := make(chan struct{})
+	s := newReconnectScheduler(100, stop)
+
+	close(stop)
+	time.Sleep(30 * time.Millisecond)
+
+	// drain whatever was issued before stopping
+	for len(s.tokens) > 0 {
+		<-s.tokens
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := s.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Wait() = %v after the scheduler stopped, want no more tokens", err)
+	}
+}
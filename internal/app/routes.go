@@ -1,9 +1,22 @@
 package app
 
-func (s *Server) setRoutes() {
-	s.router.Use(s.Middleware())
-	s.router.HandleFunc("/v3", s.handleV3)
-	s.router.HandleFunc("/v3{sub:\\@(.*)}", s.handleV3)
+import (
+	"github.com/go-chi/chi/v5"
 
-	s.router.HandleFunc("/health", s.HandleHealth)
+	"github.com/seventv/eventapi/internal/httpserver"
+)
+
+// Routes mounts the api on a router
+func (s *Server) Routes(r chi.Router) {
+	r.Group(func(r chi.Router) {
+		r.Use(s.Middleware())
+		r.HandleFunc("/v3", s.handleV3)
+		r.HandleFunc("/v3{sub:\\@(.*)}", s.handleV3)
+	})
+
+	// the health check reports the draining and broker states the middleware would reject with
+	r.HandleFunc("/health", s.HandleHealth)
+
+	// Admin endpoints stay reachable while the server is draining
+	r.With(httpserver.RequireAdminToken(s.gctx)).Post("/admin/drain", s.HandleDrain)
 }
@@ -1,33 +1,37 @@
 package app
 
 import (
-	"context"
 	"encoding/json"
 	"net/http"
-	"os"
+	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
-	"github.com/go-chi/chi/v5"
 	"github.com/gorilla/websocket"
+	"github.com/seventv/api/data/events"
 	"github.com/seventv/common/errors"
 	"github.com/valyala/fasthttp"
 	"go.uber.org/zap"
 
 	"github.com/seventv/eventapi/internal/global"
-	"github.com/seventv/eventapi/internal/nats"
-	"github.com/seventv/eventapi/internal/util"
 )
 
 type Server struct {
 	upgrader websocket.Upgrader
-	router   *chi.Mux
 
 	gctx global.Context
 
-	locked   bool
-	shutdown chan struct{}
+	// set while draining, refusing new connections and failing readiness
+	locked *int32
+	// closed when a drain starts
+	draining chan struct{}
+	drain    *drain
+	drainMtx *sync.Mutex
+
+	// closed when the broker has been down beyond the threshold, then replaced
+	outage     chan struct{}
+	outageMtx  *sync.Mutex
+	brokerDown *int32
 
 	activeConns        *int32
 	activeEventStreams *int32
@@ -44,82 +48,40 @@ func New(gctx global.Context) (*Server, <-chan struct{}) {
 
 	srv := Server{
 		upgrader: upgrader,
-		router:   chi.NewRouter(),
 
 		gctx: gctx,
 
-		shutdown: make(chan struct{}),
+		locked:   new(int32),
+		draining: make(chan struct{}),
+		drainMtx: &sync.Mutex{},
+
+		outage:     make(chan struct{}),
+		outageMtx:  &sync.Mutex{},
+		brokerDown: new(int32),
 
 		activeConns:        new(int32),
 		activeEventStreams: new(int32),
 		activeWebSockets:   new(int32),
 	}
 
-	srv.setRoutes()
-
 	srv.HandleSessionMutation(gctx)
 
-	server := http.Server{
-		Addr:        srv.gctx.Config().API.Bind,
-		IdleTimeout: 30 * time.Second,
-		Handler:     srv.router,
-		ConnContext: util.SaveConnInContext,
-	}
-
+	// Drain the connections on shutdown, carrying on with a drain that was already started.
+	// The http server is shut down once done is closed
 	done := make(chan struct{})
 	go func() {
-		if err := server.ListenAndServe(); err != nil {
-			zap.S().Fatal("failed to start server: ", err)
-		}
-	}()
-
-	// Watch for file-based shutdown signal
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		zap.S().Fatal("failed to create file watcher: ", err)
-	}
-
-	if _, err := os.Create("shutdown"); err != nil {
-		zap.S().Fatal("failed to create kill file: ", err)
-	}
-
-	if err = watcher.Add("shutdown"); err != nil {
-		zap.S().Fatal("failed to add file to watcher: ", err)
-	}
-
-	go func() {
-		select {
-		case <-gctx.Done():
-		case <-watcher.Events:
-			zap.S().Infof("received api shutdown signal via file system. closing %d connections and shuttering", atomic.LoadInt32(srv.activeConns))
-		}
+		<-gctx.Done()
 
-		close(srv.shutdown)
-		srv.locked = true
+		srv.Drain(DefaultDrainOptions(gctx, events.CloseCodeRestart))
+		srv.waitDrained()
 
-		timeout := time.After(time.Second * 30)
-		ticker := time.NewTicker(time.Millisecond * 100)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-timeout:
-				goto shutdown
-			case <-ticker.C:
-				if atomic.LoadInt32(srv.activeConns) == 0 {
-					goto shutdown
-				}
-			}
-		}
+		gctx.Inst().Broker.Close()
 
-	shutdown:
-		_ = server.Shutdown(context.Background())
-		nats.Close()
-
-		watcher.Close()
 		close(done)
 	}()
 
+	go srv.watchBroker(gctx)
+
 	go func() {
 		ticker := time.NewTicker(time.Second * 10)
 
@@ -150,26 +112,18 @@ func New(gctx global.Context) (*Server, <-chan struct{}) {
 func (s *Server) Middleware() func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
-			start := time.Now()
-			defer func() {
-				l := zap.S().With(
-					"status", r.Response.StatusCode,
-					"path", r.RequestURI,
-					"duration", time.Since(start)/time.Millisecond,
-					"ip", r.Header.Get("cf-connecting-ip"),
-					"method", r.Method,
-					"entrypoint", "api",
-				)
-
-				l.Debug("")
-			}()
 			w.Header().Set("X-Pod-Name", s.gctx.Config().Pod.Name)
 
-			if s.locked {
+			if s.Draining() {
 				writeBytesResponse(http.StatusLocked, []byte("This server is going down for restart!"), w)
 				return
 			}
 
+			if atomic.LoadInt32(s.brokerDown) == 1 {
+				writeBytesResponse(http.StatusServiceUnavailable, []byte("This server lost its connection to the message broker!"), w)
+				return
+			}
+
 			if atomic.LoadInt32(s.activeConns) >= int32(s.gctx.Config().API.ConnectionLimit) {
 				writeBytesResponse(http.StatusServiceUnavailable, []byte("This server is full!"), w)
 				return
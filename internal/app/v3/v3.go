@@ -2,17 +2,21 @@ package v3
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/seventv/api/data/events"
+	"github.com/seventv/common/utils"
 
 	client "github.com/seventv/eventapi/internal/app/connection"
 	"github.com/seventv/eventapi/internal/global"
+	"github.com/seventv/eventapi/internal/instance"
 )
 
 func WebSocket(gctx global.Context, con client.Connection) error {
@@ -60,28 +64,60 @@ func SSE(gctx global.Context, conn client.Connection, w http.ResponseWriter, r *
 			evt := matches[SSE_SUBSCRIPTION_ITEM_I_EVT]
 			cnd := matches[SSE_SUBSCRIPTION_ITEM_I_CND]
 
-			conds := strings.Split(cnd, ";")
-			cm := make(map[string]string)
+			cm, err := client.ParseCondition(cnd)
+			if err != nil {
+				e := client.NewError(client.ErrorCodeInvalidCondition, map[string]any{
+					"error": err.Error(),
+				})
 
-			for _, cond := range conds {
-				kv := strings.Split(cond, "=")
-				if len(kv) != 2 {
+				conn.SendError(e)
+
+				if conn.Mode() == client.ProtocolModeLenient {
 					continue
 				}
 
-				cm[kv[0]] = kv[1]
+				conn.SendClose(e.CloseCode(), 0)
+
+				return
 			}
 
-			if err, ok := conn.Handler().Subscribe(gctx, events.NewMessage(events.OpcodeSubscribe, events.SubscribePayload{
+			msg := events.NewMessage(events.OpcodeSubscribe, json.RawMessage(utils.ToJSON(client.SubscribePayload{
 				Type:      events.EventType(evt),
 				Condition: cm,
-			}).ToRaw()); err != nil || !ok {
+			})))
+
+			if err, ok := conn.Handler().Subscribe(gctx, client.ClientMessage{Message: msg}); err != nil || (!ok && conn.Context().Err() != nil) {
 				return
 			}
 		}
+
+		replayLastEvent(gctx, conn, r.Header.Get("Last-Event-ID"))
 	}()
 
 	conn.Read(gctx)
 
 	return nil
 }
+
+// replayLastEvent replays the dispatches missed by a client reconnecting with the id of the last event it received.
+// Without a replayable broker the ids are counted per connection, so there is nothing to replay them from
+func replayLastEvent(gctx global.Context, conn client.Connection, id string) {
+	if id == "" {
+		return
+	}
+
+	if _, ok := gctx.Inst().Broker.(instance.ReplayableBroker); !ok {
+		return
+	}
+
+	after, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return
+	}
+
+	if _, err = conn.Handler().Replay(gctx, after); err != nil {
+		conn.SendError(client.NewError(client.ErrorCodeReplayFailed, map[string]any{
+			"error": err.Error(),
+		}))
+	}
+}
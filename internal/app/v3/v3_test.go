@@ -0,0 +1,94 @@
+package v3
+
+import (
+	"context"
+	"testing"
+
+	client "github.com/seventv/eventapi/internal/app/connection"
+	"github.com/seventv/eventapi/internal/broker"
+	"github.com/seventv/eventapi/internal/configure"
+	"github.com/seventv/eventapi/internal/global"
+	"github.com/seventv/eventapi/internal/instance"
+)
+
+// replayConn records the replays and errors of a connection
+type replayConn struct {
+	client.Connection
+	replays []uint64
+	errors  []*client.Error
+	err     error
+}
+
+func (c *replayConn) Handler() client.Handler {
+	return replayHandler{c: c}
+}
+
+func (c *replayConn) SendError(e *client.Error) {
+	c.errors = append(c.errors, e)
+}
+
+type replayHandler struct {
+	client.Handler
+	c *replayConn
+}
+
+func (h replayHandler) Replay(gctx global.Context, after uint64) (int, error) {
+	h.c.replays = append(h.c.replays, after)
+
+	return 0, h.c.err
+}
+
+// replayableMemory is an in-memory broker reporting itself as replayable
+type replayableMemory struct {
+	*broker.Memory
+}
+
+func (replayableMemory) LastSequence() uint64 {
+	return 0
+}
+
+func (replayableMemory) Replay(ctx context.Context, after uint64, keys ...string) ([]*instance.BrokerMessage, error) {
+	return nil, nil
+}
+
+func TestReplayLastEvent(t *testing.T) {
+	tests := []struct {
+		name       string
+		replayable bool
+		id         string
+		err        error
+		replays    int
+		errors     int
+	}{
+		{"not replayable", false, "12", nil, 0, 0},
+		{"not replayable, no id", false, "", nil, 0, 0},
+		{"replayable", true, "12", nil, 1, 0},
+		{"replayable, no id", true, "", nil, 0, 0},
+		{"replayable, bad id", true, "abc", nil, 0, 0},
+		{"replay failed", true, "12", client.ErrReplayUnavailable, 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gctx := global.New(context.Background(), &configure.Config{})
+
+			var b instance.Broker = broker.NewMemory()
+			if tt.replayable {
+				b = replayableMemory{broker.NewMemory()}
+			}
+
+			gctx.Inst().Broker = b
+
+			conn := &replayConn{err: tt.err}
+			replayLastEvent(gctx, conn, tt.id)
+
+			if len(conn.replays) != tt.replays {
+				t.Errorf("replayed %d times, want %d", len(conn.replays), tt.replays)
+			}
+
+			if len(conn.errors) != tt.errors {
+				t.Errorf("sent %d errors, want %d", len(conn.errors), tt.errors)
+			}
+		})
+	}
+}
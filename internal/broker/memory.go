@@ -0,0 +1,38 @@
+package broker
+
+import (
+	"time"
+
+	"github.com/seventv/eventapi/internal/instance"
+)
+
+// Memory is an in-process broker, for deployments without a message bus
+// and for exercising connections in tests
+type Memory struct {
+	*Registry
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		Registry: NewRegistry(),
+	}
+}
+
+// Publish delivers a message to the local subscribers of the specified dispatch key
+func (m *Memory) Publish(key string, data []byte) {
+	m.Dispatch(&instance.BrokerMessage{
+		Key:         key,
+		Data:        data,
+		PublishedAt: time.Now(),
+	})
+}
+
+// Status implements instance.Broker
+func (m *Memory) Status() instance.BrokerStatus {
+	return instance.BrokerStatus{
+		Connected: true,
+	}
+}
+
+// Close implements instance.Broker
+func (m *Memory) Close() {}
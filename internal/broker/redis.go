@@ -0,0 +1,222 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	goRedis "github.com/go-redis/redis/v8"
+	"github.com/seventv/common/utils"
+	"go.uber.org/zap"
+
+	"github.com/seventv/eventapi/internal/instance"
+)
+
+// statusInterval is the interval of the pings keeping the status current while redis is idle
+const statusInterval = time.Second
+
+// Redis receives dispatches via Redis pub/sub, publishing on the
+// channel "<subject>.<dispatch key>"
+//
+// A pub/sub channel is subscribed to while at least one local session is interested in its key,
+// and wildcard keys are subscribed to as patterns
+type Redis struct {
+	*Registry
+
+	ctx     context.Context
+	redis   instance.Redis
+	pubsub  *goRedis.PubSub
+	subject string
+
+	status    instance.BrokerStatus
+	statusMtx sync.Mutex
+}
+
+func NewRedis(ctx context.Context, r instance.Redis, subject string) *Redis {
+	b := &Redis{
+		Registry: NewRegistry(),
+		ctx:      ctx,
+		redis:    r,
+		pubsub:   r.RawClient().Subscribe(ctx),
+		subject:  subject,
+	}
+
+	b.OnSubject = b.onSubject
+
+	// the status is recorded from the outcome of every command
+	r.RawClient().AddHook(statusHook{b})
+	b.ping()
+
+	go b.listen()
+	go b.watch()
+
+	return b
+}
+
+// Status implements instance.Broker
+func (r *Redis) Status() instance.BrokerStatus {
+	r.statusMtx.Lock()
+	defer r.statusMtx.Unlock()
+
+	return r.status
+}
+
+// watch pings redis, keeping the status current while no other commands are sent
+func (r *Redis) watch() {
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			r.ping()
+		}
+	}
+}
+
+func (r *Redis) ping() {
+	ctx, cancel := context.WithTimeout(r.ctx, time.Second)
+	defer cancel()
+
+	_ = r.redis.RawClient().Ping(ctx).Err()
+}
+
+// record updates the status with the outcome of a command
+func (r *Redis) record(err error) {
+	r.statusMtx.Lock()
+	defer r.statusMtx.Unlock()
+
+	switch {
+	case err != nil:
+		if r.status.Connected || r.status.DownSince.IsZero() {
+			r.status.DownSince = time.Now()
+		}
+
+		r.status.LastError = err.Error()
+	case !r.status.Connected && !r.status.DownSince.IsZero():
+		r.status.DownSince = time.Time{}
+		r.status.Reconnects++
+	}
+
+	r.status.Connected = err == nil
+}
+
+// statusHook records whether the commands sent to redis reached it
+type statusHook struct {
+	r *Redis
+}
+
+func (h statusHook) BeforeProcess(ctx context.Context, _ goRedis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h statusHook) AfterProcess(ctx context.Context, cmd goRedis.Cmder) error {
+	h.record(ctx, cmd.Err())
+
+	return nil
+}
+
+func (h statusHook) BeforeProcessPipeline(ctx context.Context, _ []goRedis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h statusHook) AfterProcessPipeline(ctx context.Context, cmds []goRedis.Cmder) error {
+	for _, cmd := range cmds {
+		if cmd.Err() != nil {
+			h.record(ctx, cmd.Err())
+
+			return nil
+		}
+	}
+
+	h.record(ctx, nil)
+
+	return nil
+}
+
+func (h statusHook) record(ctx context.Context, err error) {
+	// the command was given up on by its caller, which says nothing about redis
+	if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		return
+	}
+
+	// a reply, even an error or nil one, means redis was reached
+	var reply goRedis.Error
+	if err == goRedis.Nil || errors.As(err, &reply) {
+		err = nil
+	}
+
+	h.r.record(err)
+}
+
+// Close implements instance.Broker, leaving the shared client open for its other users
+func (r *Redis) Close() {
+	if err := r.pubsub.Close(); err != nil {
+		zap.S().Errorw("closing redis pubsub", "error", err)
+	}
+}
+
+// onSubject subscribes to the pub/sub channel of a key when it gains its first local subscriber
+// and unsubscribes when it loses the last
+func (r *Redis) onSubject(key string, active bool) {
+	var err error
+
+	if strings.HasSuffix(key, instance.KeyWildcard) {
+		pattern := fmt.Sprintf("%s.%s.*", r.subject, strings.TrimSuffix(key, instance.KeyWildcard))
+
+		if active {
+			err = r.pubsub.PSubscribe(r.ctx, pattern)
+		} else {
+			err = r.pubsub.PUnsubscribe(r.ctx, pattern)
+		}
+	} else {
+		name := fmt.Sprintf("%s.%s", r.subject, key)
+
+		if active {
+			err = r.pubsub.Subscribe(r.ctx, name)
+		} else {
+			err = r.pubsub.Unsubscribe(r.ctx, name)
+		}
+	}
+
+	if err != nil {
+		zap.S().Errorw("redis subscription error",
+			"error", err,
+			"key", key,
+			"active", active,
+		)
+	}
+}
+
+// listen relays the received payloads to the local subscribers
+func (r *Redis) listen() {
+	for msg := range r.pubsub.Channel() {
+		m := &instance.BrokerMessage{
+			Key:  strings.TrimPrefix(msg.Channel, r.subject+"."),
+			Data: utils.S2B(msg.Payload),
+		}
+
+		if r.Monitoring != nil {
+			r.Monitoring.EventV3().BrokerMessages.Inc()
+		}
+
+		// a message on a channel matching both a subscribed channel and pattern is received
+		// once for each, deliver them to the respective subscribers only
+		if msg.Pattern != "" {
+			// the patterns also match the keys further below them, which are left to their own patterns
+			i := strings.LastIndexByte(m.Key, '.')
+			if i == -1 || msg.Pattern != fmt.Sprintf("%s.%s.*", r.subject, m.Key[:i]) {
+				continue
+			}
+
+			r.dispatch(m, false, true)
+		} else {
+			r.dispatch(m, true, false)
+		}
+	}
+}
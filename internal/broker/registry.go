@@ -0,0 +1,339 @@
+package broker
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/seventv/eventapi/internal/instance"
+)
+
+// registryShards is the number of independently locked partitions of the subject index
+const registryShards = 64
+
+// maxRetainedDrops is the number of dropped messages a subscription retains until they are collected
+const maxRetainedDrops = 256
+
+// Registry keeps track of the local subscriptions to dispatch keys
+// and fans out incoming messages to them
+//
+// Subjects are partitioned over shards with their own locks, so that
+// dispatches and subscription changes on unrelated subjects don't contend
+type Registry struct {
+	shards [registryShards]registryShard
+
+	// OnSubject is called when a subject gains its first local subscriber, or loses its last.
+	// It is called outside of the shard's lock, as it may wait on the network, but in the order of the transitions
+	OnSubject func(subject string, active bool)
+
+	// Monitoring counts the messages received and dropped, if set
+	Monitoring instance.Monitoring
+}
+
+type registryShard struct {
+	mx sync.RWMutex
+	// held from a transition until its OnSubject call returns, keeping the calls in order
+	notifyMx sync.Mutex
+	subjects map[string]map[*Subscription]struct{} // subject as key, set of subscriptions as value
+	prefixes map[string]map[*Subscription]struct{} // subjects ending with the key wildcard, stored without it
+}
+
+func NewRegistry() *Registry {
+	r := &Registry{}
+	for i := range r.shards {
+		r.shards[i].subjects = make(map[string]map[*Subscription]struct{})
+		r.shards[i].prefixes = make(map[string]map[*Subscription]struct{})
+	}
+
+	return r
+}
+
+// NewSubscription implements instance.Broker
+func (r *Registry) NewSubscription(sessionID string) instance.BrokerSubscription {
+	return &Subscription{
+		Ch:        make(chan *instance.BrokerMessage, 10),
+		sessionID: sessionID,
+		registry:  r,
+		subjects:  make(map[string]struct{}),
+		prefixes:  make(map[string]struct{}),
+	}
+}
+
+// Dispatch delivers a message to every subscription of its key or of a wildcard matching it,
+// decoding it once beforehand so that recipients share the result
+func (r *Registry) Dispatch(msg *instance.BrokerMessage) {
+	if r.Monitoring != nil {
+		r.Monitoring.EventV3().BrokerMessages.Inc()
+	}
+
+	r.dispatch(msg, true, true)
+}
+
+// dispatch delivers a message to the subscriptions of its exact key and/or of its prefix
+func (r *Registry) dispatch(msg *instance.BrokerMessage, exact, prefix bool) {
+	shard := r.shard(msg.Key)
+
+	p, pshard := "", (*registryShard)(nil)
+	if i := strings.LastIndexByte(msg.Key, '.'); i != -1 && prefix {
+		p = msg.Key[:i]
+		pshard = r.shard(p)
+	}
+
+	n := 0
+
+	if exact {
+		shard.mx.RLock()
+		n += len(shard.subjects[msg.Key])
+		shard.mx.RUnlock()
+	}
+
+	if pshard != nil {
+		pshard.mx.RLock()
+		np := len(pshard.prefixes[p])
+		pshard.mx.RUnlock()
+
+		// without subscribers to the prefix, the subscriptions to the key don't need to be checked against it
+		if np == 0 {
+			pshard = nil
+		}
+
+		n += np
+	}
+
+	if n == 0 {
+		return
+	}
+
+	if err := msg.Decode(); err != nil {
+		zap.S().Errorw("dispatch unmarshal error",
+			"error", err,
+			"key", msg.Key,
+		)
+
+		return
+	}
+
+	msg.QueuedAt = time.Now()
+
+	if published := msg.PublishTime(); r.Monitoring != nil && !published.IsZero() {
+		r.Monitoring.EventV3().BrokerLatency.Observe(msg.QueuedAt.Sub(published).Seconds())
+	}
+
+	if pshard != nil {
+		pshard.mx.RLock()
+		for sub := range pshard.prefixes[p] {
+			sub.deliver(msg)
+		}
+		pshard.mx.RUnlock()
+	}
+
+	if exact {
+		shard.mx.RLock()
+		for sub := range shard.subjects[msg.Key] {
+			// skip the subscriptions that received the message via its prefix
+			if pshard != nil && sub.hasPrefix(p) {
+				continue
+			}
+
+			sub.deliver(msg)
+		}
+		shard.mx.RUnlock()
+	}
+}
+
+func (r *Registry) shard(subject string) *registryShard {
+	// inlined FNV-1a, avoiding an allocation per lookup
+	h := uint32(2166136261)
+	for i := 0; i < len(subject); i++ {
+		h ^= uint32(subject[i])
+		h *= 16777619
+	}
+
+	return &r.shards[h%registryShards]
+}
+
+// index returns the shard and map a subject is stored in, along with its key there
+func (r *Registry) index(subject string) (*registryShard, map[string]map[*Subscription]struct{}, string) {
+	if strings.HasSuffix(subject, instance.KeyWildcard) {
+		p := strings.TrimSuffix(subject, instance.KeyWildcard)
+		shard := r.shard(p)
+
+		return shard, shard.prefixes, p
+	}
+
+	shard := r.shard(subject)
+
+	return shard, shard.subjects, subject
+}
+
+func (r *Registry) add(sub *Subscription, subject string) {
+	shard, m, k := r.index(subject)
+
+	shard.mx.Lock()
+
+	subs, ok := m[k]
+	if !ok {
+		subs = make(map[*Subscription]struct{})
+		m[k] = subs
+	}
+
+	subs[sub] = struct{}{}
+
+	r.notify(shard, subject, !ok, true)
+}
+
+func (r *Registry) remove(sub *Subscription, subject string) {
+	shard, m, k := r.index(subject)
+
+	shard.mx.Lock()
+
+	subs, ok := m[k]
+	if ok {
+		delete(subs, sub)
+
+		if len(subs) == 0 {
+			delete(m, k)
+		}
+	}
+
+	r.notify(shard, subject, ok && len(subs) == 0, false)
+}
+
+// notify unlocks a shard, then calls OnSubject if the subject went through a transition.
+// The notify lock is taken before the shard's is released, so that a later transition of the subject can't be reported first
+func (r *Registry) notify(shard *registryShard, subject string, transition bool, active bool) {
+	if !transition || r.OnSubject == nil {
+		shard.mx.Unlock()
+		return
+	}
+
+	shard.notifyMx.Lock()
+	shard.mx.Unlock()
+
+	defer shard.notifyMx.Unlock()
+
+	r.OnSubject(subject, active)
+}
+
+type Subscription struct {
+	Ch        chan *instance.BrokerMessage
+	sessionID string
+	registry  *Registry
+
+	// the subjects this subscription is registered to
+	mx       sync.Mutex
+	subjects map[string]struct{}
+
+	// the wildcard subjects without their suffix, read while dispatching.
+	// set after registering and cleared before unregistering, so that races deliver twice rather than never
+	prefixMx sync.RWMutex
+	prefixes map[string]struct{}
+
+	// the messages dropped since they were last collected, and the number of others beyond the retained ones
+	dropMx  sync.Mutex
+	drops   []*instance.BrokerMessage
+	dropped uint64
+}
+
+func (s *Subscription) Channel() chan *instance.BrokerMessage {
+	return s.Ch
+}
+
+func (s *Subscription) Subscribe(subscribeTo ...string) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	for _, join := range subscribeTo {
+		if _, ok := s.subjects[join]; ok {
+			continue
+		}
+
+		s.subjects[join] = struct{}{}
+		s.registry.add(s, join)
+		s.setPrefix(join, true)
+	}
+}
+
+func (s *Subscription) Unsubscribe(unsubscribeFrom ...string) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	for _, unsub := range unsubscribeFrom {
+		if _, ok := s.subjects[unsub]; !ok {
+			continue
+		}
+
+		delete(s.subjects, unsub)
+		s.setPrefix(unsub, false)
+		s.registry.remove(s, unsub)
+	}
+}
+
+func (s *Subscription) Close() {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	for subject := range s.subjects {
+		s.setPrefix(subject, false)
+		s.registry.remove(s, subject)
+	}
+
+	s.subjects = make(map[string]struct{})
+}
+
+func (s *Subscription) deliver(msg *instance.BrokerMessage) {
+	select {
+	case s.Ch <- msg:
+	default:
+		s.dropMx.Lock()
+		if len(s.drops) < maxRetainedDrops {
+			s.drops = append(s.drops, msg)
+		} else {
+			s.dropped++
+		}
+		s.dropMx.Unlock()
+
+		if s.registry.Monitoring != nil {
+			s.registry.Monitoring.EventV3().BrokerDropped.WithLabelValues("slow_connection").Inc()
+		}
+
+		zap.S().Debug("channel blocked dropping message: ", msg.Key)
+	}
+}
+
+// Dropped implements instance.BrokerSubscription
+func (s *Subscription) Dropped() ([]*instance.BrokerMessage, uint64) {
+	s.dropMx.Lock()
+	defer s.dropMx.Unlock()
+
+	msgs, more := s.drops, s.dropped
+	s.drops, s.dropped = nil, 0
+
+	return msgs, more
+}
+
+func (s *Subscription) hasPrefix(p string) bool {
+	s.prefixMx.RLock()
+	defer s.prefixMx.RUnlock()
+
+	_, ok := s.prefixes[p]
+
+	return ok
+}
+
+func (s *Subscription) setPrefix(subject string, active bool) {
+	if !strings.HasSuffix(subject, instance.KeyWildcard) {
+		return
+	}
+
+	s.prefixMx.Lock()
+	defer s.prefixMx.Unlock()
+
+	if active {
+		s.prefixes[strings.TrimSuffix(subject, instance.KeyWildcard)] = struct{}{}
+	} else {
+		delete(s.prefixes, strings.TrimSuffix(subject, instance.KeyWildcard))
+	}
+}
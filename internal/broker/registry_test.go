@@ -0,0 +1,346 @@
+package broker
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/seventv/eventapi/internal/instance"
+)
+
+func drain(sub instance.BrokerSubscription, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-sub.Channel():
+		}
+	}
+}
+
+// TestRegistryChurn subscribes, unsubscribes and dispatches on shared subjects at once, meant to be run with -race
+func TestRegistryChurn(t *testing.T) {
+	r := NewRegistry()
+
+	subjects := []string{"emote_set.update", "user.update", "emote.create", "emote_set" + instance.KeyWildcard}
+
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < 16; i++ {
+		sub := r.NewSubscription(fmt.Sprintf("session-%d", i))
+
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+
+			drain(sub, stop)
+		}()
+
+		go func(seed int64) {
+			defer wg.Done()
+			defer sub.Close()
+
+			rnd := rand.New(rand.NewSource(seed))
+
+			for n := 0; n < 500; n++ {
+				subject := subjects[rnd.Intn(len(subjects))]
+
+				if rnd.Intn(2) == 0 {
+					sub.Subscribe(subject)
+				} else {
+					sub.Unsubscribe(subject)
+				}
+			}
+		}(int64(i))
+	}
+
+	dispatchers := sync.WaitGroup{}
+
+	for i := 0; i < 4; i++ {
+		dispatchers.Add(1)
+
+		go func() {
+			defer dispatchers.Done()
+
+			for n := 0; n < 2000; n++ {
+				r.Dispatch(&instance.BrokerMessage{
+					Key:  subjects[n%3],
+					Data: []byte(`{}`),
+				})
+			}
+		}()
+	}
+
+	dispatchers.Wait()
+	close(stop)
+	wg.Wait()
+
+	for i := range r.shards {
+		if n := len(r.shards[i].subjects) + len(r.shards[i].prefixes); n != 0 {
+			t.Fatalf("shard %d still indexes %d subjects after every subscription closed", i, n)
+		}
+	}
+}
+
+func TestRegistryOnSubject(t *testing.T) {
+	r := NewRegistry()
+
+	mx := sync.Mutex{}
+	calls := map[string][]bool{}
+
+	r.OnSubject = func(subject string, active bool) {
+		mx.Lock()
+		defer mx.Unlock()
+
+		calls[subject] = append(calls[subject], active)
+	}
+
+	a := r.NewSubscription("a")
+	b := r.NewSubscription("b")
+
+	a.Subscribe("user.update", "emote_set"+instance.KeyWildcard)
+	b.Subscribe("user.update", "emote_set"+instance.KeyWildcard)
+	a.Subscribe("user.update")
+
+	a.Unsubscribe("user.update")
+	b.Unsubscribe("user.update")
+	b.Unsubscribe("user.update")
+
+	a.Close()
+	b.Close()
+
+	for _, subject := range []string{"user.update", "emote_set" + instance.KeyWildcard} {
+		if got := calls[subject]; len(got) != 2 || !got[0] || got[1] {
+			t.Errorf("OnSubject(%q) called with %v, want [true false]", subject, got)
+		}
+	}
+}
+
+// TestSubscriptionDropped checks that the messages dropped by a full subscription are retained until collected,
+// counting those beyond the limit
+func TestSubscriptionDropped(t *testing.T) {
+	r := NewRegistry()
+
+	sub := r.NewSubscription("a")
+	sub.Subscribe("user.update")
+
+	buffered := cap(sub.Channel())
+
+	for i := 0; i < buffered+maxRetainedDrops+5; i++ {
+		r.Dispatch(&instance.BrokerMessage{
+			Key:  "user.update",
+			Data: []byte(`{}`),
+		})
+	}
+
+	msgs, more := sub.Dropped()
+	if len(msgs) != maxRetainedDrops || more != 5 {
+		t.Fatalf("Dropped() = %d messages and %d more, want %d and 5", len(msgs), more, maxRetainedDrops)
+	}
+
+	if msgs, more := sub.Dropped(); len(msgs) != 0 || more != 0 {
+		t.Errorf("Dropped() = %d messages and %d more the second time, want none", len(msgs), more)
+	}
+
+	if n := len(sub.Channel()); n != buffered {
+		t.Errorf("%d messages in the channel, want %d", n, buffered)
+	}
+}
+
+// TestRegistryOnSubjectChurn checks that the transitions of a subject are reported in order
+// while subscriptions come and go concurrently
+func TestRegistryOnSubjectChurn(t *testing.T) {
+	r := NewRegistry()
+
+	mx := sync.Mutex{}
+	active := false
+
+	r.OnSubject = func(subject string, a bool) {
+		mx.Lock()
+		defer mx.Unlock()
+
+		if a == active {
+			t.Errorf("OnSubject(%q, %t) called twice in a row", subject, a)
+		}
+
+		active = a
+	}
+
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < 16; i++ {
+		sub := r.NewSubscription(fmt.Sprintf("session-%d", i))
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for n := 0; n < 500; n++ {
+				sub.Subscribe("user.update")
+				sub.Unsubscribe("user.update")
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if active {
+		t.Error("the subject is still active without subscribers")
+	}
+}
+
+// singleMapRegistry is the design the registry replaced, indexing every subject in one map behind one lock
+type singleMapRegistry struct {
+	mx       sync.Mutex
+	subjects map[string][]chan *instance.BrokerMessage
+}
+
+func (r *singleMapRegistry) Dispatch(msg *instance.BrokerMessage) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	_ = msg.Decode()
+
+	for _, ch := range r.subjects[msg.Key] {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+func (r *singleMapRegistry) Subscribe(ch chan *instance.BrokerMessage, subject string) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.subjects[subject] = append(r.subjects[subject], ch)
+}
+
+func (r *singleMapRegistry) Unsubscribe(ch chan *instance.BrokerMessage, subject string) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	subs := r.subjects[subject]
+	for i := range subs {
+		if subs[i] == ch {
+			subs[i] = subs[len(subs)-1]
+			r.subjects[subject] = subs[:len(subs)-1]
+
+			break
+		}
+	}
+}
+
+// BenchmarkRegistryDispatch dispatches in parallel, on its own and while sessions keep subscribing and unsubscribing
+func BenchmarkRegistryDispatch(b *testing.B) {
+	const (
+		subjects    = 1000
+		subscribers = 5000
+		churners    = 4
+	)
+
+	keys := make([]string, subjects)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("emote_set.update.%d", i)
+	}
+
+	// churn runs subscribe and unsubscribe in a loop on each goroutine until stop is closed
+	churn := func(stop <-chan struct{}, subscribe func(i int, key string), unsubscribe func(i int, key string)) {
+		for i := 0; i < churners; i++ {
+			go func(i int) {
+				for n := 0; ; n++ {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+
+					subscribe(i, keys[n%subjects])
+					unsubscribe(i, keys[n%subjects])
+				}
+			}(i)
+		}
+	}
+
+	dispatch := func(b *testing.B, fn func(msg *instance.BrokerMessage)) {
+		b.ResetTimer()
+
+		b.RunParallel(func(pb *testing.PB) {
+			n := rand.Int()
+			for pb.Next() {
+				n++
+				fn(&instance.BrokerMessage{Key: keys[n%subjects], Data: []byte(`{}`)})
+			}
+		})
+	}
+
+	for _, withChurn := range []bool{false, true} {
+		name := map[bool]string{false: "", true: "/churn"}[withChurn]
+
+		b.Run("sharded"+name, func(b *testing.B) {
+			r := NewRegistry()
+			stop := make(chan struct{})
+			defer close(stop)
+
+			for i := 0; i < subscribers; i++ {
+				sub := r.NewSubscription(fmt.Sprintf("session-%d", i))
+				sub.Subscribe(keys[i%subjects])
+
+				go drain(sub, stop)
+			}
+
+			if withChurn {
+				subs := make([]instance.BrokerSubscription, churners)
+				for i := range subs {
+					subs[i] = r.NewSubscription(fmt.Sprintf("churn-%d", i))
+				}
+
+				churn(stop,
+					func(i int, key string) { subs[i].Subscribe(key) },
+					func(i int, key string) { subs[i].Unsubscribe(key) },
+				)
+			}
+
+			dispatch(b, r.Dispatch)
+		})
+
+		b.Run("single_map"+name, func(b *testing.B) {
+			r := &singleMapRegistry{subjects: map[string][]chan *instance.BrokerMessage{}}
+			stop := make(chan struct{})
+			defer close(stop)
+
+			for i := 0; i < subscribers; i++ {
+				ch := make(chan *instance.BrokerMessage, 10)
+				r.Subscribe(ch, keys[i%subjects])
+
+				go func() {
+					for {
+						select {
+						case <-stop:
+							return
+						case <-ch:
+						}
+					}
+				}()
+			}
+
+			if withChurn {
+				chs := make([]chan *instance.BrokerMessage, churners)
+				for i := range chs {
+					chs[i] = make(chan *instance.BrokerMessage, 10)
+				}
+
+				churn(stop,
+					func(i int, key string) { r.Subscribe(chs[i], key) },
+					func(i int, key string) { r.Unsubscribe(chs[i], key) },
+				)
+			}
+
+			dispatch(b, r.Dispatch)
+		})
+	}
+}
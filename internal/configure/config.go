@@ -23,13 +23,60 @@ type Config struct {
 		Database   int      `mapstructure:"database" json:"database"`
 		Sentinel   bool     `mapstructure:"sentinel" json:"sentinel"`
 		MasterName string   `mapstructure:"master_name" json:"master_name"`
+		// Prefix of the pub/sub channels dispatches are published on
+		Subject string `mapstructure:"subject" json:"subject"`
 	} `mapstructure:"redis" json:"redis"`
 
 	Nats struct {
 		Url     string `mapstructure:"url" json:"url"`
 		Subject string `mapstructure:"subject" json:"subject"`
+		// Connection name reported to the server, the pod name if empty
+		Name string `mapstructure:"name" json:"name"`
+
+		// Authentication
+		CredsFile    string `mapstructure:"creds_file" json:"creds_file"`
+		NKeySeedFile string `mapstructure:"nkey_seed_file" json:"nkey_seed_file"`
+		Token        string `mapstructure:"token" json:"token"`
+		Username     string `mapstructure:"username" json:"username"`
+		Password     string `mapstructure:"password" json:"password"`
+
+		TLS struct {
+			Enabled            bool   `mapstructure:"enabled" json:"enabled"`
+			CertFile           string `mapstructure:"cert_file" json:"cert_file"`
+			KeyFile            string `mapstructure:"key_file" json:"key_file"`
+			CAFile             string `mapstructure:"ca_file" json:"ca_file"`
+			InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify" json:"insecure_skip_verify"`
+		} `mapstructure:"tls" json:"tls"`
+
+		// Delay between reconnect attempts in milliseconds
+		ReconnectWait int `mapstructure:"reconnect_wait" json:"reconnect_wait"`
+		// Random delay added to each reconnect attempt in milliseconds
+		ReconnectJitter int `mapstructure:"reconnect_jitter" json:"reconnect_jitter"`
+		// Reconnect attempts before giving up, unlimited if 0 or negative
+		MaxReconnects int `mapstructure:"max_reconnects" json:"max_reconnects"`
+		// Size in bytes of the buffer holding outgoing data while reconnecting
+		ReconnectBufferSize int `mapstructure:"reconnect_buffer_size" json:"reconnect_buffer_size"`
+
+		JetStream struct {
+			// Consume the events stream with an ordered consumer, allowing missed dispatches to be replayed
+			Enabled bool `mapstructure:"enabled" json:"enabled"`
+			// Name of the stream capturing the subject, looked up by subject if empty
+			Stream string `mapstructure:"stream" json:"stream"`
+			// Maximum number of stream messages a replay may walk through
+			ReplayLimit uint64 `mapstructure:"replay_limit" json:"replay_limit"`
+			// Time limit of a replay in milliseconds
+			ReplayTimeout int `mapstructure:"replay_timeout" json:"replay_timeout"`
+		} `mapstructure:"jetstream" json:"jetstream"`
 	} `mapstructure:"nats" json:"nats"`
 
+	Broker struct {
+		// The backend dispatches are received from: nats (default), redis or memory
+		Kind BrokerKind `mapstructure:"kind" json:"kind"`
+		// Time in milliseconds the broker may stay disconnected before clients are told
+		// to reconnect to another server, disabled if negative
+		DownThreshold int `mapstructure:"down_threshold" json:"down_threshold"`
+	} `mapstructure:"broker" json:"broker"`
+
 	API struct {
 		Enabled           bool   `mapstructure:"enabled" json:"enabled"`
 		Bind              string `mapstructure:"bind" json:"bind"`
@@ -38,12 +85,52 @@ type Config struct {
 		ConnectionLimit   int32  `mapstructure:"connection_limit" json:"connection_limit"`
 		// Connection time limit in minutes
 		TTL int `mapstructure:"ttl" json:"ttl"`
+		// Random time in milliseconds added to the connection time limit, a tenth of it if 0, disabled if negative
+		TTLJitter int `mapstructure:"ttl_jitter" json:"ttl_jitter"`
+		// Connections told to reconnect per second across the pod
+		ReconnectRate int `mapstructure:"reconnect_rate" json:"reconnect_rate"`
 
 		V1 bool `mapstructure:"v1" json:"v1"`
 		V3 bool `mapstructure:"v3" json:"v3"`
 
 		// URL to the eventbridge api
 		BridgeURL string `mapstructure:"bridge_url" json:"bridge_url"`
+
+		WebSocket struct {
+			// Interval in milliseconds between protocol-level pings, disabled if negative
+			PingInterval int `mapstructure:"ping_interval" json:"ping_interval"`
+			// Time in milliseconds a client has to answer a ping before its connection is considered dead
+			PongTimeout int `mapstructure:"pong_timeout" json:"pong_timeout"`
+			// Maximum size in bytes of a message sent by a client
+			MaxMessageSize int64 `mapstructure:"max_message_size" json:"max_message_size"`
+		} `mapstructure:"websocket" json:"websocket"`
+
+		EventStream struct {
+			// Interval in milliseconds between keepalive comments sent on idle streams, disabled if negative
+			KeepaliveInterval int `mapstructure:"keepalive_interval" json:"keepalive_interval"`
+			// Time in milliseconds a write may take before the stream is considered dead
+			WriteTimeout int `mapstructure:"write_timeout" json:"write_timeout"`
+		} `mapstructure:"eventstream" json:"eventstream"`
+
+		// How connections are closed when the server shuts down or is drained by an administrator
+		Drain struct {
+			// Time in milliseconds between failing readiness and closing the first connection, disabled if negative
+			ReadinessDelay int `mapstructure:"readiness_delay" json:"readiness_delay"`
+			// Time in milliseconds a drain may take before the remaining connections are closed at once
+			Duration int `mapstructure:"duration" json:"duration"`
+			// Connections closed per second, only limited by the reconnect rate if 0
+			Rate int `mapstructure:"rate" json:"rate"`
+			// Pod suggested to clients told to reconnect by a drain
+			Hint string `mapstructure:"hint" json:"hint"`
+		} `mapstructure:"drain" json:"drain"`
+
+		// The latest messages retained by each WebSocket session, which a client noticing a gap in sequences can replay
+		History struct {
+			// Number of messages retained, disabled if negative
+			Size int `mapstructure:"size" json:"size"`
+			// Total size in bytes of the messages retained
+			MaxBytes int `mapstructure:"max_bytes" json:"max_bytes"`
+		} `mapstructure:"history" json:"history"`
 	} `mapstructure:"api" json:"api"`
 
 	Monitoring struct {
@@ -60,13 +147,56 @@ type Config struct {
 	Health struct {
 		Enabled bool   `mapstructure:"enabled" json:"enabled"`
 		Bind    string `mapstructure:"bind" json:"bind"`
+		// Time in milliseconds the runtime may be late by before the pod is not ready, disabled if negative
+		MaxLag int `mapstructure:"max_lag" json:"max_lag"`
+		// Remaining connections below which the pod is not ready
+		MinCapacity int `mapstructure:"min_capacity" json:"min_capacity"`
 	} `mapstructure:"health" json:"health"`
 
+	// OpenTelemetry tracing, spans are dropped unless enabled
+	Tracing struct {
+		Enabled bool `mapstructure:"enabled" json:"enabled"`
+		// Address of the OTLP/HTTP collector, localhost:4318 if empty
+		Endpoint string `mapstructure:"endpoint" json:"endpoint"`
+		// Send spans without TLS
+		Insecure bool `mapstructure:"insecure" json:"insecure"`
+		// Ratio of new traces recorded, 0.1 if 0, none if negative
+		SampleRate float64 `mapstructure:"sample_rate" json:"sample_rate"`
+		// Ratio of dispatched messages recorded on the connections they are written to, 0.001 if 0, none if negative
+		DispatchSampleRate float64 `mapstructure:"dispatch_sample_rate" json:"dispatch_sample_rate"`
+	} `mapstructure:"tracing" json:"tracing"`
+
+	// Access logs of the http entrypoints
+	AccessLog struct {
+		// Ratio of requests logged, 0.01 if 0, only the failed ones if negative
+		SampleRate float64 `mapstructure:"sample_rate" json:"sample_rate"`
+	} `mapstructure:"access_log" json:"access_log"`
+
+	// Addresses or CIDR ranges of the proxies trusted to report the address of the clients in the
+	// Cf-Connecting-Ip, X-Forwarded-For and X-Real-Ip headers, which are ignored from any other peer
+	TrustedProxies []string `mapstructure:"trusted_proxies" json:"trusted_proxies"`
+
+	Admin struct {
+		// Bearer token required by the admin and pprof endpoints, which are disabled if empty
+		Token string `mapstructure:"token" json:"token"`
+	} `mapstructure:"admin" json:"admin"`
+
 	Pod struct {
 		Name string `mapstructure:"name" json:"name"`
 	} `mapstructure:"pod" json:"pod"`
+
+	// the sources the config was read from, read again on reload
+	viper *viper.Viper
 }
 
+type BrokerKind string
+
+const (
+	BrokerKindNats   BrokerKind = "nats"
+	BrokerKindRedis  BrokerKind = "redis"
+	BrokerKindMemory BrokerKind = "memory"
+)
+
 type KeyValue struct {
 	Key   string `mapstructure:"key" json:"key"`
 	Value string `mapstructure:"value" json:"value"`
@@ -115,9 +245,12 @@ func New() *Config {
 	config.AllowEmptyEnv(true)
 
 	// Print final config
-	c := &Config{}
+	c := &Config{viper: config}
 	checkErr(config.Unmarshal(&c))
 
+	applyDefaults(c)
+	checkErr(c.Validate())
+
 	initLogging(c.Level)
 
 	return c
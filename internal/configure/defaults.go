@@ -0,0 +1,96 @@
+package configure
+
+// applyDefaults fills in the settings left unset, keeping the negative values which disable a feature
+func applyDefaults(c *Config) {
+	if c.Broker.Kind == "" {
+		c.Broker.Kind = BrokerKindNats
+	}
+
+	if c.Broker.DownThreshold == 0 {
+		c.Broker.DownThreshold = 30000
+	}
+
+	if c.Nats.ReconnectWait <= 0 {
+		c.Nats.ReconnectWait = 2000
+	}
+
+	if c.Nats.ReconnectJitter <= 0 {
+		c.Nats.ReconnectJitter = 1000
+	}
+
+	if c.Nats.JetStream.ReplayLimit == 0 {
+		c.Nats.JetStream.ReplayLimit = 10000
+	}
+
+	if c.Nats.JetStream.ReplayTimeout <= 0 {
+		c.Nats.JetStream.ReplayTimeout = 5000
+	}
+
+	if c.API.HeartbeatInterval == 0 {
+		c.API.HeartbeatInterval = 45000
+	}
+
+	if c.API.ReconnectRate == 0 {
+		c.API.ReconnectRate = 250
+	}
+
+	if c.API.TTLJitter == 0 {
+		c.API.TTLJitter = c.API.TTL * 60000 / 10
+	}
+
+	if c.API.WebSocket.PingInterval == 0 {
+		c.API.WebSocket.PingInterval = 15000
+	}
+
+	if c.API.WebSocket.PongTimeout <= 0 {
+		c.API.WebSocket.PongTimeout = 10000
+	}
+
+	if c.API.WebSocket.MaxMessageSize <= 0 {
+		c.API.WebSocket.MaxMessageSize = 65536
+	}
+
+	if c.API.EventStream.KeepaliveInterval == 0 {
+		c.API.EventStream.KeepaliveInterval = 10000
+	}
+
+	if c.API.EventStream.WriteTimeout <= 0 {
+		c.API.EventStream.WriteTimeout = 5000
+	}
+
+	if c.API.Drain.ReadinessDelay == 0 {
+		c.API.Drain.ReadinessDelay = 5000
+	}
+
+	if c.API.Drain.Duration <= 0 {
+		c.API.Drain.Duration = 30000
+	}
+
+	if c.API.History.Size == 0 {
+		c.API.History.Size = 64
+	}
+
+	if c.API.History.MaxBytes <= 0 {
+		c.API.History.MaxBytes = 65536
+	}
+
+	if c.Health.MaxLag == 0 {
+		c.Health.MaxLag = 1000
+	}
+
+	if c.Health.MinCapacity <= 0 {
+		c.Health.MinCapacity = 1
+	}
+
+	if c.AccessLog.SampleRate == 0 {
+		c.AccessLog.SampleRate = 0.01
+	}
+
+	if c.Tracing.SampleRate == 0 {
+		c.Tracing.SampleRate = 0.1
+	}
+
+	if c.Tracing.DispatchSampleRate == 0 {
+		c.Tracing.DispatchSampleRate = 0.001
+	}
+}
@@ -1,6 +1,7 @@
 package configure
 
 import (
+	"fmt"
 	"io"
 	"log"
 
@@ -8,31 +9,45 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
+// logLevel is the level of the global logger, which changes when the config is reloaded
+var logLevel = zap.NewAtomicLevel()
+
 func initLogging(level string) {
 	log.SetOutput(io.Discard)
 
-	var lvl zapcore.Level
+	setLevel(level)
+
+	cfg := zap.NewProductionConfig()
+	cfg.Level = logLevel
+	logger, _ := cfg.Build()
+
+	zap.ReplaceGlobals(logger)
+}
+
+func setLevel(level string) {
+	lvl, err := parseLevel(level)
+	if err != nil {
+		lvl = zap.InfoLevel
+	}
 
+	logLevel.SetLevel(lvl)
+}
+
+func parseLevel(level string) (zapcore.Level, error) {
 	switch level {
 	case "debug":
-		lvl = zap.DebugLevel
-	case "info":
-		lvl = zap.InfoLevel
+		return zap.DebugLevel, nil
+	case "info", "":
+		return zap.InfoLevel, nil
 	case "warn":
-		lvl = zap.WarnLevel
+		return zap.WarnLevel, nil
 	case "error":
-		lvl = zap.ErrorLevel
+		return zap.ErrorLevel, nil
 	case "panic":
-		lvl = zap.PanicLevel
+		return zap.PanicLevel, nil
 	case "fatal":
-		lvl = zap.FatalLevel
+		return zap.FatalLevel, nil
 	default:
-		lvl = zap.InfoLevel
+		return zap.InfoLevel, fmt.Errorf("unknown log level %q", level)
 	}
-
-	cfg := zap.NewProductionConfig()
-	cfg.Level = zap.NewAtomicLevelAt(lvl)
-	logger, _ := cfg.Build()
-
-	zap.ReplaceGlobals(logger)
 }
@@ -0,0 +1,119 @@
+package configure
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// editors and kubernetes write a config file in several steps, the reload waits for them to settle
+const reloadDelay = time.Millisecond * 500
+
+// Reload reads the config sources again. The returned config is a copy of c in which
+// only the settings that are safe to change at runtime are updated:
+// the log level, the heartbeat interval, the subscription and connection limits and the bridge url.
+//
+// Connections copy the heartbeat interval and the subscription limit they announce when they open,
+// so these only change for the connections opened after the reload, though the new limit is enforced on all of them.
+// Defaults derived from other settings, such as ttl_jitter from ttl, only change on restart along with them
+func (c *Config) Reload() (*Config, error) {
+	if err := c.viper.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	next := &Config{viper: c.viper}
+	if err := c.viper.Unmarshal(next); err != nil {
+		return nil, err
+	}
+
+	applyDefaults(next)
+	if err := next.Validate(); err != nil {
+		return nil, err
+	}
+
+	reloaded := *c
+	reloaded.Level = next.Level
+	reloaded.API.HeartbeatInterval = next.API.HeartbeatInterval
+	reloaded.API.SubscriptionLimit = next.API.SubscriptionLimit
+	reloaded.API.ConnectionLimit = next.API.ConnectionLimit
+	reloaded.API.BridgeURL = next.API.BridgeURL
+
+	if !reflect.DeepEqual(reloaded, *next) {
+		zap.S().Warn("the config has changes which are only applied on restart")
+	}
+
+	setLevel(reloaded.Level)
+
+	return &reloaded, nil
+}
+
+// Watch reloads the config when its file changes or a signal is received, until ctx is done.
+// Reloaded configs are passed to apply, invalid ones are logged and ignored.
+// Without a config file, the config is only reloaded on signals
+func Watch(ctx context.Context, current func() *Config, signals <-chan os.Signal, apply func(*Config)) {
+	var (
+		file    string
+		changes <-chan fsnotify.Event
+		errs    <-chan error
+	)
+
+	if used := current().viper.ConfigFileUsed(); used != "" {
+		file = filepath.Clean(used)
+
+		watcher, err := fsnotify.NewWatcher()
+		if err == nil {
+			defer watcher.Close()
+
+			// the directory is watched as the file is usually replaced rather than written to
+			err = watcher.Add(filepath.Dir(file))
+		}
+
+		if err != nil {
+			zap.S().Warnw("failed to watch the config file, it is only reloaded on SIGHUP", "error", err)
+		} else {
+			changes = watcher.Events
+			errs = watcher.Errors
+		}
+	}
+
+	settle := time.NewTimer(reloadDelay)
+	settle.Stop()
+
+	defer settle.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-changes:
+			// kubernetes swaps the ..data symlink of a mounted config map
+			if filepath.Clean(e.Name) == file || filepath.Base(e.Name) == "..data" {
+				settle.Reset(reloadDelay)
+			}
+
+			continue
+		case err := <-errs:
+			zap.S().Warnw("config file watcher", "error", err)
+
+			continue
+		case s := <-signals:
+			zap.S().Infow("reloading the config", "signal", s.String())
+		case <-settle.C:
+			zap.S().Infow("reloading the config", "file", file)
+		}
+
+		cfg, err := current().Reload()
+		if err != nil {
+			zap.S().Errorw("failed to reload the config, keeping the current one", "error", err)
+
+			continue
+		}
+
+		apply(cfg)
+	}
+}
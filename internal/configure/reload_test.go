@@ -0,0 +1,208 @@
+package configure
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+const testConfig = `
+nats:
+  url: nats://localhost:4222
+  subject: events
+api:
+  enabled: true
+  bind: 0.0.0.0:3000
+  subscription_limit: %d
+  connection_limit: 10000
+  ttl: %d
+`
+
+func writeConfig(t *testing.T, file string, subscriptionLimit int, ttl int) {
+	t.Helper()
+
+	b := []byte(fmt.Sprintf(testConfig, subscriptionLimit, ttl))
+
+	// replaced rather than written to, as editors and kubernetes do
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Rename(tmp, file); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// loadConfig reads a config the way New does, without its flags and environment
+func loadConfig(t *testing.T, file string) *Config {
+	t.Helper()
+
+	v := viper.New()
+	v.SetConfigFile(file)
+
+	if err := v.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Config{viper: v}
+	if err := v.Unmarshal(c); err != nil {
+		t.Fatal(err)
+	}
+
+	applyDefaults(c)
+
+	return c
+}
+
+func TestReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, file, 500, 30)
+
+	c := loadConfig(t, file)
+
+	writeConfig(t, file, 100, 60)
+
+	next, err := c.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if next.API.SubscriptionLimit != 100 {
+		t.Errorf("subscription_limit = %d after reloading, want 100", next.API.SubscriptionLimit)
+	}
+
+	if next.API.TTL != 30 {
+		t.Errorf("ttl = %d after reloading, want it to only change on restart", next.API.TTL)
+	}
+
+	if c.API.SubscriptionLimit != 500 {
+		t.Errorf("the current config was modified, subscription_limit = %d", c.API.SubscriptionLimit)
+	}
+
+	writeConfig(t, file, 0, 30)
+
+	if _, err := c.Reload(); err == nil {
+		t.Error("Reload() = nil with an invalid config")
+	}
+}
+
+// observeLogs captures the global logs until the test ends
+func observeLogs(t *testing.T) *observer.ObservedLogs {
+	core, logs := observer.New(zap.InfoLevel)
+	t.Cleanup(zap.ReplaceGlobals(zap.New(core)))
+
+	return logs
+}
+
+// reloads returns the reloads logged with the specified field
+func reloads(logs *observer.ObservedLogs, field string) int {
+	n := 0
+
+	for _, e := range logs.FilterMessage("reloading the config").All() {
+		if _, ok := e.ContextMap()[field]; ok {
+			n++
+		}
+	}
+
+	return n
+}
+
+func TestWatch(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, file, 500, 30)
+
+	c := loadConfig(t, file)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	applied := make(chan *Config, 1)
+	signals := make(chan os.Signal, 1)
+
+	go Watch(ctx, func() *Config { return c }, signals, func(next *Config) {
+		applied <- next
+	})
+
+	// let the watcher start
+	time.Sleep(100 * time.Millisecond)
+
+	writeConfig(t, file, 100, 30)
+
+	select {
+	case next := <-applied:
+		if next.API.SubscriptionLimit != 100 {
+			t.Errorf("subscription_limit = %d after the file changed, want 100", next.API.SubscriptionLimit)
+		}
+	case <-time.After(reloadDelay + 2*time.Second):
+		t.Fatal("the config was not reloaded after its file changed")
+	}
+
+	signals <- syscall.SIGHUP
+
+	select {
+	case <-applied:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the config was not reloaded on SIGHUP")
+	}
+}
+
+func TestWatchWithoutFile(t *testing.T) {
+	logs := observeLogs(t)
+
+	dir := t.TempDir()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = os.Chdir(wd)
+	})
+
+	c := &Config{viper: viper.New()}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+
+	go Watch(ctx, func() *Config { return c }, signals, func(*Config) {})
+
+	time.Sleep(100 * time.Millisecond)
+
+	// the working directory must not be watched in place of the missing file
+	if err := os.WriteFile(filepath.Join(dir, "..data"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(reloadDelay + 200*time.Millisecond)
+
+	if n := reloads(logs, "file"); n != 0 {
+		t.Fatalf("reloaded %d times on changes to the working directory", n)
+	}
+
+	signals <- syscall.SIGHUP
+
+	deadline := time.Now().Add(2 * time.Second)
+	for reloads(logs, "signal") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the config was not reloaded on SIGHUP")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
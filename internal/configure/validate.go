@@ -0,0 +1,107 @@
+package configure
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// Validate reports the settings the server cannot run with, named as in the config file
+func (c *Config) Validate() error {
+	var err *multierror.Error
+
+	invalid := func(format string, args ...any) {
+		err = multierror.Append(err, fmt.Errorf(format, args...))
+	}
+
+	if _, e := parseLevel(c.Level); e != nil {
+		invalid("level: %s", e)
+	}
+
+	switch c.Broker.Kind {
+	case BrokerKindNats:
+		if c.Nats.Url == "" {
+			invalid("nats.url: must be set when broker.kind is nats")
+		}
+
+		if c.Nats.Subject == "" {
+			invalid("nats.subject: must be set when broker.kind is nats")
+		}
+	case BrokerKindRedis:
+		if len(c.Redis.Addresses) == 0 {
+			invalid("redis.addresses: must be set when broker.kind is redis")
+		}
+	case BrokerKindMemory:
+	default:
+		invalid("broker.kind: unknown broker %q, expected nats, redis or memory", c.Broker.Kind)
+	}
+
+	if c.API.Enabled {
+		if c.API.SubscriptionLimit <= 0 {
+			invalid("api.subscription_limit: must be positive, clients could not subscribe to anything")
+		}
+
+		if c.API.ConnectionLimit <= 0 {
+			invalid("api.connection_limit: must be positive, every connection would be refused")
+		}
+
+		if c.API.TTL <= 0 {
+			invalid("api.ttl: must be positive, connections would be told to reconnect as soon as they open")
+		}
+
+		if c.API.ReconnectRate < 0 {
+			invalid("api.reconnect_rate: must not be negative")
+		}
+
+		if c.API.Drain.Rate < 0 {
+			invalid("api.drain.rate: must not be negative, set it to 0 to only limit drains by api.reconnect_rate")
+		}
+
+		if c.API.BridgeURL != "" {
+			if u, e := url.Parse(c.API.BridgeURL); e != nil || u.Scheme == "" || u.Host == "" {
+				invalid("api.bridge_url: %q is not an absolute url", c.API.BridgeURL)
+			}
+		}
+	}
+
+	if c.PProf.Enabled && c.Admin.Token == "" {
+		invalid("pprof.enabled: the profiler requires admin.token to be set")
+	}
+
+	for _, entrypoint := range []struct {
+		name    string
+		enabled bool
+		bind    string
+	}{
+		{"api", c.API.Enabled, c.API.Bind},
+		{"pprof", c.PProf.Enabled, c.PProf.Bind},
+		{"health", c.Health.Enabled, c.Health.Bind},
+		{"monitoring", c.Monitoring.Enabled, c.Monitoring.Bind},
+	} {
+		if entrypoint.enabled && entrypoint.bind == "" {
+			invalid("%s.bind: must be set when %s is enabled", entrypoint.name, entrypoint.name)
+		}
+	}
+
+	for _, proxy := range c.TrustedProxies {
+		if _, _, e := net.ParseCIDR(proxy); e != nil && net.ParseIP(proxy) == nil {
+			invalid("trusted_proxies: %q is not an address or a CIDR range", proxy)
+		}
+	}
+
+	if c.AccessLog.SampleRate > 1 {
+		invalid("access_log.sample_rate: must be at most 1")
+	}
+
+	if c.Tracing.SampleRate > 1 {
+		invalid("tracing.sample_rate: must be at most 1")
+	}
+
+	if c.Tracing.DispatchSampleRate > 1 {
+		invalid("tracing.dispatch_sample_rate: must be at most 1")
+	}
+
+	return err.ErrorOrNil()
+}
@@ -0,0 +1,96 @@
+package configure
+
+import (
+	"strings"
+	"testing"
+)
+
+// validConfig returns the smallest config which passes validation
+func validConfig() *Config {
+	c := &Config{}
+	c.Nats.Url = "nats://localhost:4222"
+	c.Nats.Subject = "events"
+	c.API.Enabled = true
+	c.API.Bind = "0.0.0.0:3000"
+	c.API.SubscriptionLimit = 500
+	c.API.ConnectionLimit = 10000
+	c.API.TTL = 30
+
+	applyDefaults(c)
+
+	return c
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   []string // the settings reported, none if valid
+	}{
+		{"valid", func(c *Config) {}, nil},
+		{"unknown level", func(c *Config) { c.Level = "loud" }, []string{"level"}},
+
+		{"nats without url", func(c *Config) { c.Nats.Url = "" }, []string{"nats.url"}},
+		{"nats without subject", func(c *Config) { c.Nats.Subject = "" }, []string{"nats.subject"}},
+		{"redis without addresses", func(c *Config) { c.Broker.Kind = BrokerKindRedis }, []string{"redis.addresses"}},
+		{"memory without settings", func(c *Config) {
+			c.Broker.Kind = BrokerKindMemory
+			c.Nats.Url, c.Nats.Subject = "", ""
+		}, nil},
+		{"unknown broker", func(c *Config) { c.Broker.Kind = "kafka" }, []string{"broker.kind"}},
+
+		{"no subscriptions", func(c *Config) { c.API.SubscriptionLimit = 0 }, []string{"api.subscription_limit"}},
+		{"no connections", func(c *Config) { c.API.ConnectionLimit = -1 }, []string{"api.connection_limit"}},
+		{"no ttl", func(c *Config) { c.API.TTL = 0 }, []string{"api.ttl"}},
+		{"negative reconnect rate", func(c *Config) { c.API.ReconnectRate = -1 }, []string{"api.reconnect_rate"}},
+		{"negative drain rate", func(c *Config) { c.API.Drain.Rate = -10 }, []string{"api.drain.rate"}},
+		{"drain limited by the reconnect rate", func(c *Config) { c.API.Drain.Rate = 0 }, nil},
+		{"relative bridge url", func(c *Config) { c.API.BridgeURL = "/bridge" }, []string{"api.bridge_url"}},
+		{"api disabled", func(c *Config) {
+			c.API.Enabled = false
+			c.API.Bind = ""
+			c.API.ReconnectRate = -1
+		}, nil},
+
+		{"pprof without admin token", func(c *Config) {
+			c.PProf.Enabled = true
+			c.PProf.Bind = "0.0.0.0:6060"
+		}, []string{"pprof.enabled"}},
+		{"health without bind", func(c *Config) { c.Health.Enabled = true }, []string{"health.bind"}},
+		{"trusted proxies", func(c *Config) { c.TrustedProxies = []string{"10.0.0.0/8", "127.0.0.1", "::1"} }, nil},
+		{"invalid trusted proxy", func(c *Config) { c.TrustedProxies = []string{"10.0.0.0/33"} }, []string{"trusted_proxies"}},
+		{"sample rate above 1", func(c *Config) { c.Tracing.SampleRate = 1.5 }, []string{"tracing.sample_rate"}},
+
+		{"several mistakes", func(c *Config) {
+			c.Nats.Url = ""
+			c.API.ReconnectRate = -1
+			c.API.Drain.Rate = -1
+		}, []string{"nats.url", "api.reconnect_rate", "api.drain.rate"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			tt.modify(c)
+
+			err := c.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("Validate() = nil, want %v to be reported", tt.want)
+			}
+
+			for _, setting := range tt.want {
+				if !strings.Contains(err.Error(), setting+":") {
+					t.Errorf("Validate() = %v, want %s to be reported", err, setting)
+				}
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package global
 
 import (
 	"context"
+	"sync/atomic"
 	"time"
 
 	"github.com/seventv/eventapi/internal/configure"
@@ -13,12 +14,14 @@ type Context interface {
 	Value(key interface{}) interface{}
 	Done() <-chan struct{}
 	Config() *configure.Config
+	// SetConfig replaces the config of this context and of every context sharing its root, when reloaded
+	SetConfig(config *configure.Config)
 	Inst() *Instances
 }
 
 type gCtx struct {
 	ctx    context.Context
-	config *configure.Config
+	config *atomic.Pointer[configure.Config]
 	inst   *Instances
 }
 
@@ -39,7 +42,11 @@ func (g *gCtx) Value(key interface{}) interface{} {
 }
 
 func (g *gCtx) Config() *configure.Config {
-	return g.config
+	return g.config.Load()
+}
+
+func (g *gCtx) SetConfig(config *configure.Config) {
+	g.config.Store(config)
 }
 
 func (g *gCtx) Inst() *Instances {
@@ -49,13 +56,29 @@ func (g *gCtx) Inst() *Instances {
 func New(ctx context.Context, config *configure.Config) Context {
 	return &gCtx{
 		ctx:    ctx,
-		config: config,
+		config: newConfig(config),
 		inst:   &Instances{},
 	}
 }
 
+// sharedConfig returns the config of a context, shared so that derived contexts see reloads
+func sharedConfig(ctx Context) *atomic.Pointer[configure.Config] {
+	if g, ok := ctx.(*gCtx); ok {
+		return g.config
+	}
+
+	return newConfig(ctx.Config())
+}
+
+func newConfig(config *configure.Config) *atomic.Pointer[configure.Config] {
+	p := &atomic.Pointer[configure.Config]{}
+	p.Store(config)
+
+	return p
+}
+
 func WithCancel(ctx Context) (Context, context.CancelFunc) {
-	cfg := ctx.Config()
+	cfg := sharedConfig(ctx)
 	inst := ctx.Inst()
 
 	c, cancel := context.WithCancel(ctx)
@@ -68,7 +91,7 @@ func WithCancel(ctx Context) (Context, context.CancelFunc) {
 }
 
 func WithDeadline(ctx Context, deadline time.Time) (Context, context.CancelFunc) {
-	cfg := ctx.Config()
+	cfg := sharedConfig(ctx)
 	inst := ctx.Inst()
 
 	c, cancel := context.WithDeadline(ctx, deadline)
@@ -81,7 +104,7 @@ func WithDeadline(ctx Context, deadline time.Time) (Context, context.CancelFunc)
 }
 
 func WithValue(ctx Context, key interface{}, value interface{}) Context {
-	cfg := ctx.Config()
+	cfg := sharedConfig(ctx)
 	inst := ctx.Inst()
 
 	return &gCtx{
@@ -92,7 +115,7 @@ func WithValue(ctx Context, key interface{}, value interface{}) Context {
 }
 
 func WithTimeout(ctx Context, timeout time.Duration) (Context, context.CancelFunc) {
-	cfg := ctx.Config()
+	cfg := sharedConfig(ctx)
 	inst := ctx.Inst()
 
 	c, cancel := context.WithTimeout(ctx, timeout)
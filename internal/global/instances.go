@@ -4,6 +4,8 @@ import "github.com/seventv/eventapi/internal/instance"
 
 type Instances struct {
 	Redis            instance.Redis
+	Broker           instance.Broker
 	Monitoring       instance.Monitoring
+	Reconnects       instance.Reconnects
 	ConcurrencyValue int32
 }
@@ -0,0 +1,108 @@
+package health
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/seventv/common/utils"
+
+	"github.com/seventv/eventapi/internal/app"
+	"github.com/seventv/eventapi/internal/global"
+)
+
+const lagInterval = time.Millisecond * 500
+
+// Check is the outcome of one of the checks making up the readiness of the pod
+type Check struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Report is the body of a probe response
+type Report struct {
+	OK     bool             `json:"ok"`
+	Checks map[string]Check `json:"checks,omitempty"`
+}
+
+// lagMonitor measures how late the runtime schedules a goroutine,
+// which grows when the pod is too busy to serve its connections in time
+type lagMonitor struct {
+	lag *int64 // nanoseconds
+}
+
+func newLagMonitor(gctx global.Context) *lagMonitor {
+	m := &lagMonitor{
+		lag: new(int64),
+	}
+
+	go func() {
+		timer := time.NewTimer(lagInterval)
+		defer timer.Stop()
+
+		for {
+			start := time.Now()
+
+			select {
+			case <-gctx.Done():
+				return
+			case <-timer.C:
+				atomic.StoreInt64(m.lag, int64(time.Since(start)-lagInterval))
+
+				timer.Reset(lagInterval)
+			}
+		}
+	}()
+
+	return m
+}
+
+func (m *lagMonitor) Lag() time.Duration {
+	return time.Duration(atomic.LoadInt64(m.lag))
+}
+
+// readiness checks whether the pod should receive new connections
+func readiness(gctx global.Context, srv *app.Server, lag *lagMonitor) Report {
+	checks := map[string]Check{}
+
+	status := gctx.Inst().Broker.Status()
+	checks["broker"] = Check{
+		OK:     status.Connected,
+		Detail: utils.Ternary(status.Connected, "", fmt.Sprintf("down since %s: %s", status.DownSince.Format(time.RFC3339), status.LastError)),
+	}
+
+	cfg := gctx.Config().Health
+	if cfg.MaxLag >= 0 {
+		max := time.Duration(cfg.MaxLag) * time.Millisecond
+
+		checks["lag"] = Check{
+			OK:     lag.Lag() < max,
+			Detail: lag.Lag().String(),
+		}
+	}
+
+	if srv != nil {
+		draining := srv.Draining()
+		checks["draining"] = Check{
+			OK:     !draining,
+			Detail: utils.Ternary(draining, "the server is draining its connections", ""),
+		}
+
+		remaining := gctx.Config().API.ConnectionLimit - srv.GetConcurrentConnections()
+		checks["capacity"] = Check{
+			OK:     remaining >= int32(cfg.MinCapacity),
+			Detail: fmt.Sprintf("%d connections remaining", remaining),
+		}
+	}
+
+	report := Report{
+		OK:     true,
+		Checks: checks,
+	}
+
+	for _, c := range checks {
+		report.OK = report.OK && c.OK
+	}
+
+	return report
+}
@@ -1,59 +1,74 @@
 package health
 
 import (
-	"time"
+	"net/http"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/seventv/common/utils"
-	"github.com/valyala/fasthttp"
 	"go.uber.org/zap"
 
 	"github.com/seventv/eventapi/internal/app"
 	"github.com/seventv/eventapi/internal/global"
 )
 
-func New(gctx global.Context, srv *app.Server) <-chan struct{} {
-	server := fasthttp.Server{
-		Handler: func(ctx *fasthttp.RequestCtx) {
-			start := time.Now()
-			defer func() {
-				l := zap.S().With(
-					"status", ctx.Response.StatusCode(),
-					"duration", time.Since(start)/time.Millisecond,
-					"entrypoint", "health",
-				)
-				if err := recover(); err != nil {
-					l.Error("panic in handler: ", err)
-				} else {
-					l.Info("")
-				}
-			}()
-
-			ctx.Response.Header.Set("Cache-Control", "no-cache, no-store, must-revalidate")
-			ctx.SetStatusCode(200)
-
-			// check if path is /concurrency
-			if !ctx.IsGet() || utils.B2S(ctx.URI().Path()) == "/concurrency" && srv != nil && srv.GetConcurrentConnections() >= (gctx.Config().API.ConnectionLimit-1) {
+// Routes returns the routes of the probes, reporting on the api server if it's enabled
+func Routes(gctx global.Context, srv *app.Server) func(r chi.Router) {
+	lag := newLagMonitor(gctx)
+
+	return func(r chi.Router) {
+		r.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+
+				next.ServeHTTP(w, r)
+			})
+		})
+
+		// the process is able to respond
+		livez := func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, Report{OK: true})
+		}
+
+		r.Get("/livez", livez)
+
+		// the ingress routes the public /health path to this server,
+		// unless it shares the bind of the api which serves its own health check
+		if srv == nil || gctx.Config().Health.Bind != gctx.Config().API.Bind {
+			r.Get("/health", livez)
+		}
+
+		// the pod should receive new connections
+		r.Get("/readyz", func(w http.ResponseWriter, r *http.Request) {
+			report := readiness(gctx, srv, lag)
+
+			writeJSON(w, utils.Ternary(report.OK, http.StatusOK, http.StatusServiceUnavailable), report)
+		})
+
+		// report the state of the message broker
+		r.Get("/broker", func(w http.ResponseWriter, r *http.Request) {
+			status := gctx.Inst().Broker.Status()
+
+			writeJSON(w, utils.Ternary(status.Connected, http.StatusOK, http.StatusServiceUnavailable), status)
+		})
+
+		r.Get("/concurrency", func(w http.ResponseWriter, r *http.Request) {
+			if srv != nil && srv.GetConcurrentConnections() >= (gctx.Config().API.ConnectionLimit-1) {
 				zap.S().Warnw("connection limit reached")
 
-				ctx.SetBodyString("Maximum Concurrency")
-				ctx.SetStatusCode(410)
+				w.WriteHeader(http.StatusGone)
+				_, _ = w.Write([]byte("Maximum Concurrency"))
+
+				return
 			}
-		},
-		GetOnly:          true,
-		DisableKeepalive: true,
+
+			w.WriteHeader(http.StatusOK)
+		})
 	}
+}
 
-	go func() {
-		if err := server.ListenAndServe(gctx.Config().Health.Bind); err != nil {
-			zap.S().Fatal("failed to start health bind: ", err)
-		}
-	}()
-
-	done := make(chan struct{})
-	go func() {
-		<-gctx.Done()
-		_ = server.Shutdown()
-		close(done)
-	}()
-	return done
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	_, _ = w.Write(utils.ToJSON(v))
 }
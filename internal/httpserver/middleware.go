@@ -0,0 +1,256 @@
+package httpserver
+
+import (
+	"context"
+	crand "crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"math/rand"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/seventv/common/utils"
+	"go.uber.org/zap"
+
+	"github.com/seventv/eventapi/internal/global"
+)
+
+const RequestIDHeader = "X-Request-Id"
+
+type requestIDKey struct{}
+
+// RequestID identifies each request, keeping the id set by a proxy in front of the server if any
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" {
+			b := make([]byte, 12)
+			_, _ = crand.Read(b)
+
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// GetRequestID returns the id of the request a context belongs to, empty if there is none
+func GetRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+
+	return id
+}
+
+// Recover logs the panics of handlers instead of losing the connection without a trace
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+
+			// used by handlers to abort a response on purpose
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			zap.S().Errorw("panic in handler",
+				"error", err,
+				"path", r.URL.Path,
+				"request_id", GetRequestID(r.Context()),
+				"stack", string(debug.Stack()),
+			)
+
+			// fails silently if the response was already started or the connection hijacked
+			w.WriteHeader(http.StatusInternalServerError)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type accessLogKey struct{}
+
+// accessLogEntry holds what a handler tells about a request for its access log
+type accessLogEntry struct {
+	transport string
+	sessionID string
+}
+
+// SetConnection records the connection opened by a request in its access log
+func SetConnection(ctx context.Context, transport string, sessionID string) {
+	if entry, ok := ctx.Value(accessLogKey{}).(*accessLogEntry); ok {
+		entry.transport = transport
+		entry.sessionID = sessionID
+	}
+}
+
+// probePaths are polled by the orchestrator and the metrics scraper, and left out of the access log
+var probePaths = map[string]bool{
+	"/livez":       true,
+	"/readyz":      true,
+	"/health":      true,
+	"/broker":      true,
+	"/concurrency": true,
+	"/metrics":     true,
+}
+
+// AccessLog logs the requests received by an entrypoint once they are answered,
+// which is when an event stream closes. Failed requests are logged regardless of sampling, probes never are
+func AccessLog(gctx global.Context, entrypoint string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if probePaths[r.URL.Path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+
+			rw := newResponseRecorder(w)
+			entry := &accessLogEntry{}
+
+			next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), accessLogKey{}, entry)))
+
+			// net/http answers with 200 if the handler wrote nothing
+			status := utils.Ternary(rw.Status() == 0, http.StatusOK, rw.Status())
+			if status < http.StatusInternalServerError && rand.Float64() >= gctx.Config().AccessLog.SampleRate {
+				return
+			}
+
+			zap.S().Infow("request",
+				"entrypoint", entrypoint,
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", status,
+				"bytes", rw.Bytes(),
+				"duration_ms", time.Since(start).Milliseconds(),
+				"transport", entry.transport,
+				"session_id", entry.sessionID,
+				"ip", ClientIP(r),
+				"request_id", GetRequestID(r.Context()),
+			)
+		})
+	}
+}
+
+type clientIPKey struct{}
+
+// ClientAddress resolves the address of the client behind the proxies in front of the server.
+// The headers reporting it are only read from the trusted proxies, as clients could otherwise spoof it
+func ClientAddress(gctx global.Context) func(http.Handler) http.Handler {
+	trusted := parseNetworks(gctx.Config().TrustedProxies)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := resolveClientIP(r, trusted)
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip)))
+		})
+	}
+}
+
+// ClientIP returns the address of the client resolved by ClientAddress, the address of the peer if it wasn't
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+
+	return peerIP(r)
+}
+
+func resolveClientIP(r *http.Request, trusted []*net.IPNet) string {
+	peer := peerIP(r)
+	if !isTrusted(peer, trusted) {
+		return peer
+	}
+
+	if ip := r.Header.Get("Cf-Connecting-Ip"); ip != "" {
+		return ip
+	}
+
+	// each proxy appends the address it received the request from, the client being the last untrusted one
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := strings.TrimSpace(hops[i])
+			if i == 0 || !isTrusted(ip, trusted) {
+				return ip
+			}
+		}
+	}
+
+	if ip := r.Header.Get("X-Real-Ip"); ip != "" {
+		return ip
+	}
+
+	return peer
+}
+
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// parseNetworks parses the validated addresses and CIDR ranges of the trusted proxies
+func parseNetworks(proxies []string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(proxies))
+
+	for _, p := range proxies {
+		if _, n, err := net.ParseCIDR(p); err == nil {
+			networks = append(networks, n)
+		} else if ip := net.ParseIP(p); ip != nil {
+			bits := utils.Ternary(ip.To4() != nil, 32, 128)
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		}
+	}
+
+	return networks
+}
+
+func isTrusted(addr string, trusted []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RequireAdminToken only lets through requests carrying the admin token as a bearer token.
+// The routes are hidden while no token is configured
+func RequireAdminToken(gctx global.Context) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := gctx.Config().Admin.Token
+			if token == "" {
+				http.NotFound(w, r)
+				return
+			}
+
+			auth := r.Header.Get("Authorization")
+			if !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/seventv/eventapi/internal/configure"
+	"github.com/seventv/eventapi/internal/global"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	trusted := []string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"}
+
+	tests := []struct {
+		name    string
+		peer    string
+		headers map[string]string
+		want    string
+	}{
+		{"untrusted peer", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"untrusted peer spoofing cloudflare", "203.0.113.7:5000", map[string]string{"Cf-Connecting-Ip": "1.1.1.1"}, "203.0.113.7"},
+		{"untrusted peer spoofing forwarded", "203.0.113.7:5000", map[string]string{"X-Forwarded-For": "1.1.1.1"}, "203.0.113.7"},
+		{"untrusted peer spoofing real ip", "203.0.113.7:5000", map[string]string{"X-Real-Ip": "1.1.1.1"}, "203.0.113.7"},
+		{"peer next to a trusted range", "11.0.0.1:5000", map[string]string{"X-Forwarded-For": "1.1.1.1"}, "11.0.0.1"},
+
+		{"trusted peer without headers", "10.1.2.3:5000", nil, "10.1.2.3"},
+		{"trusted range", "10.1.2.3:5000", map[string]string{"Cf-Connecting-Ip": "1.1.1.1"}, "1.1.1.1"},
+		{"trusted address", "192.168.1.1:5000", map[string]string{"X-Real-Ip": "1.1.1.1"}, "1.1.1.1"},
+		{"address next to a trusted one", "192.168.1.2:5000", map[string]string{"X-Real-Ip": "1.1.1.1"}, "192.168.1.2"},
+		{"trusted ipv6 range", "[fd00::1]:5000", map[string]string{"X-Forwarded-For": "2001:db8::1"}, "2001:db8::1"},
+		{"cloudflare first", "10.1.2.3:5000", map[string]string{"Cf-Connecting-Ip": "1.1.1.1", "X-Forwarded-For": "2.2.2.2", "X-Real-Ip": "3.3.3.3"}, "1.1.1.1"},
+		{"forwarded before real ip", "10.1.2.3:5000", map[string]string{"X-Forwarded-For": "2.2.2.2", "X-Real-Ip": "3.3.3.3"}, "2.2.2.2"},
+
+		{"forwarded through trusted proxies", "10.1.2.3:5000", map[string]string{"X-Forwarded-For": "1.1.1.1, 10.0.0.5, 192.168.1.1"}, "1.1.1.1"},
+		{"forwarded with a spoofed first hop", "10.1.2.3:5000", map[string]string{"X-Forwarded-For": "9.9.9.9, 1.1.1.1, 10.0.0.5"}, "1.1.1.1"},
+		{"forwarded through trusted proxies only", "10.1.2.3:5000", map[string]string{"X-Forwarded-For": "10.0.0.6, 10.0.0.5"}, "10.0.0.6"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &configure.Config{}
+			cfg.TrustedProxies = trusted
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.peer
+
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+
+			var got string
+
+			ClientAddress(global.New(context.Background(), cfg))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = ClientIP(r)
+			})).ServeHTTP(httptest.NewRecorder(), r)
+
+			if got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPWithoutMiddleware(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "203.0.113.7:5000"
+	r.Header.Set("X-Forwarded-For", "1.1.1.1")
+
+	if got := ClientIP(r); got != "203.0.113.7" {
+		t.Errorf("ClientIP() = %q, want the address of the peer", got)
+	}
+}
+
+func TestNoTrustedProxies(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.1.2.3:5000"
+	r.Header.Set("X-Forwarded-For", "1.1.1.1")
+
+	if got := resolveClientIP(r, parseNetworks(nil)); got != "10.1.2.3" {
+		t.Errorf("resolveClientIP() = %q, want the address of the peer", got)
+	}
+}
@@ -0,0 +1,77 @@
+package httpserver
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// responseRecorder records the status and the size of a response. It keeps supporting the
+// hijacking the WebSocket upgrade relies on and the flushing of event streams
+type responseRecorder struct {
+	http.ResponseWriter
+
+	status *int32
+	bytes  *int64
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{
+		ResponseWriter: w,
+		status:         new(int32),
+		bytes:          new(int64),
+	}
+}
+
+func (rw *responseRecorder) WriteHeader(code int) {
+	atomic.CompareAndSwapInt32(rw.status, 0, int32(code))
+
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseRecorder) Write(b []byte) (int, error) {
+	atomic.CompareAndSwapInt32(rw.status, 0, http.StatusOK)
+
+	n, err := rw.ResponseWriter.Write(b)
+	atomic.AddInt64(rw.bytes, int64(n))
+
+	return n, err
+}
+
+func (rw *responseRecorder) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hands the connection over to the handler, which writes the response itself
+func (rw *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response writer does not support hijacking")
+	}
+
+	conn, brw, err := h.Hijack()
+	if err == nil {
+		atomic.CompareAndSwapInt32(rw.status, 0, http.StatusSwitchingProtocols)
+	}
+
+	return conn, brw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rw *responseRecorder) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Status returns the status the response was written with, 0 if nothing was written yet
+func (rw *responseRecorder) Status() int {
+	return int(atomic.LoadInt32(rw.status))
+}
+
+// Bytes returns the size of the response body written so far
+func (rw *responseRecorder) Bytes() int64 {
+	return atomic.LoadInt64(rw.bytes)
+}
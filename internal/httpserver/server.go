@@ -0,0 +1,97 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/seventv/eventapi/internal/global"
+	"github.com/seventv/eventapi/internal/util"
+)
+
+const shutdownTimeout = time.Second * 5
+
+// Manager serves the HTTP entrypoints of the EventAPI. Entrypoints bound to the same address
+// share a server, which allows the auxiliary endpoints to be served on the port of the api
+type Manager struct {
+	gctx    global.Context
+	routers map[string]*chi.Mux
+	binds   []string
+}
+
+func New(gctx global.Context) *Manager {
+	return &Manager{
+		gctx:    gctx,
+		routers: map[string]*chi.Mux{},
+	}
+}
+
+// Mount adds the routes of an entrypoint to the server listening on bind
+func (m *Manager) Mount(entrypoint string, bind string, routes func(r chi.Router)) {
+	router, ok := m.routers[bind]
+	if !ok {
+		router = chi.NewRouter()
+		router.Use(RequestID, ClientAddress(m.gctx))
+
+		m.routers[bind] = router
+		m.binds = append(m.binds, bind)
+	}
+
+	router.Group(func(r chi.Router) {
+		// recovering within the access log records the status of the requests which panicked
+		r.Use(AccessLog(m.gctx, entrypoint), Recover)
+
+		routes(r)
+	})
+}
+
+// Serve starts the servers. Once the global context is done they are shut down
+// after the specified channels are closed, so that they stay up while connections are drained
+func (m *Manager) Serve(after ...<-chan struct{}) <-chan struct{} {
+	servers := make([]*http.Server, len(m.binds))
+
+	for i, bind := range m.binds {
+		server := &http.Server{
+			Addr:        bind,
+			IdleTimeout: 30 * time.Second,
+			Handler:     m.routers[bind],
+			ConnContext: util.SaveConnInContext,
+		}
+
+		go func() {
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				zap.S().Fatalw("failed to start http server", "bind", server.Addr, "error", err)
+			}
+		}()
+
+		servers[i] = server
+	}
+
+	done := make(chan struct{})
+	go func() {
+		<-m.gctx.Done()
+
+		for _, ch := range after {
+			<-ch
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		for _, server := range servers {
+			if err := server.Shutdown(ctx); err != nil {
+				zap.S().Warnw("http server did not shut down in time", "bind", server.Addr, "error", err)
+
+				_ = server.Close()
+			}
+		}
+
+		close(done)
+	}()
+
+	return done
+}
@@ -0,0 +1,165 @@
+package instance
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/seventv/api/data/events"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Broker receives dispatches from the message bus and fans them out
+// to the sessions subscribed to their dispatch keys
+type Broker interface {
+	// NewSubscription creates a subscription delivering dispatches for the specified session
+	NewSubscription(sessionID string) BrokerSubscription
+	// Status reports the state of the connection to the backend
+	Status() BrokerStatus
+	// Close stops receiving messages and releases the connection to the backend
+	Close()
+}
+
+type BrokerStatus struct {
+	Connected bool `json:"connected"`
+	// The time the connection was lost, zero while connected
+	DownSince time.Time `json:"down_since"`
+	// The number of times the connection was re-established
+	Reconnects uint64 `json:"reconnects"`
+	// The last error reported by the backend
+	LastError string `json:"last_error,omitempty"`
+}
+
+// ReplayableBroker is a broker retaining published messages in a stream,
+// allowing sessions to recover the dispatches they missed
+type ReplayableBroker interface {
+	Broker
+	// LastSequence returns the stream sequence of the last processed message
+	LastSequence() uint64
+	// Replay retrieves the messages published with the specified keys after the given stream sequence
+	Replay(ctx context.Context, after uint64, keys ...string) ([]*BrokerMessage, error)
+}
+
+// KeyWildcard ends a key matching every key with one more segment after it,
+// such as all dispatch keys of an event type regardless of their condition
+const KeyWildcard = ".>"
+
+type BrokerSubscription interface {
+	// Channel returns the channel which dispatches are delivered to
+	Channel() chan *BrokerMessage
+	// Subscribe starts delivering dispatches published with the specified keys.
+	// A key ending with KeyWildcard matches the keys below it
+	Subscribe(keys ...string)
+	// Unsubscribe stops delivering dispatches published with the specified keys
+	Unsubscribe(keys ...string)
+	// Close removes all of the subscription's keys
+	Close()
+	// Dropped collects the dispatches dropped because the channel was full since the previous call,
+	// along with the number of others which were too many to retain
+	Dropped() (msgs []*BrokerMessage, more uint64)
+}
+
+type BrokerMessage struct {
+	// The dispatch key the message was published with
+	Key string
+	// The encoded dispatch
+	Data []byte
+	// The stream sequence of the message, zero if the broker does not retain messages
+	Sequence uint64
+	// The time the message was published at according to the publisher, zero if it was not reported
+	PublishedAt time.Time
+	// The time the message was handed to the local subscriptions
+	QueuedAt time.Time
+	// The span of the publisher, invalid if it did not propagate one
+	SpanContext trace.SpanContext
+
+	// The decoded dispatch, shared by all recipients and never to be modified
+	Dispatch events.Message[events.DispatchPayload]
+
+	decodeOnce sync.Once
+	decodeErr  error
+	frames     sync.Map
+	sampleOnce sync.Once
+	sampled    bool
+}
+
+// NewDispatchMessage wraps a dispatch that did not come from the broker, such as a bridged event
+func NewDispatchMessage(msg events.Message[events.DispatchPayload]) *BrokerMessage {
+	m := &BrokerMessage{
+		Dispatch: msg,
+	}
+
+	m.decodeOnce.Do(func() {})
+
+	return m
+}
+
+// PublishedAtHeader is the header carrying the time a message was published at, in unix milliseconds
+const PublishedAtHeader = "Published-At"
+
+// ParsePublishedAt parses the value of a PublishedAtHeader, returning the zero time if it's invalid
+func ParsePublishedAt(v string) time.Time {
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || ms <= 0 {
+		return time.Time{}
+	}
+
+	return time.UnixMilli(ms)
+}
+
+// PublishTime returns the time the message was published at, falling back to the timestamp of the dispatch.
+// It is zero if neither is known
+func (m *BrokerMessage) PublishTime() time.Time {
+	if !m.PublishedAt.IsZero() {
+		return m.PublishedAt
+	}
+
+	if m.Dispatch.Timestamp > 0 {
+		return time.UnixMilli(m.Dispatch.Timestamp)
+	}
+
+	return time.Time{}
+}
+
+// Sampled decides whether the message is traced, only calling sample the first time
+// so that every connection the message is written to agrees
+func (m *BrokerMessage) Sampled(sample func() bool) bool {
+	m.sampleOnce.Do(func() {
+		m.sampled = sample()
+	})
+
+	return m.sampled
+}
+
+// Decode parses the dispatch, only doing so the first time it's called
+func (m *BrokerMessage) Decode() error {
+	m.decodeOnce.Do(func() {
+		m.decodeErr = json.Unmarshal(m.Data, &m.Dispatch)
+	})
+
+	return m.decodeErr
+}
+
+// Frame returns an encoding of the dispatch shared by its recipients,
+// calling render to create it the first time the key is requested
+func (m *BrokerMessage) Frame(key string, render func() (any, error)) (any, error) {
+	f, ok := m.frames.Load(key)
+	if !ok {
+		f, _ = m.frames.LoadOrStore(key, &brokerFrame{})
+	}
+
+	frame := f.(*brokerFrame)
+	frame.once.Do(func() {
+		frame.value, frame.err = render()
+	})
+
+	return frame.value, frame.err
+}
+
+type brokerFrame struct {
+	once  sync.Once
+	value any
+	err   error
+}
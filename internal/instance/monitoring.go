@@ -10,11 +10,33 @@ type Monitoring interface {
 }
 
 type EventV3 struct {
-	TotalConnections               prometheus.Histogram
-	TotalConnectionDurationSeconds prometheus.Histogram
-	CurrentConnections             prometheus.Gauge
-	CurrentEventStreams            prometheus.Gauge
-	CurrentWebSockets              prometheus.Gauge
-	Heartbeats                     prometheus.Histogram
-	Dispatches                     prometheus.Histogram
+	// Connections
+	Connections         *prometheus.CounterVec   // transport
+	ConnectionDuration  *prometheus.HistogramVec // transport
+	CurrentConnections  prometheus.Gauge
+	CurrentEventStreams prometheus.Gauge
+	CurrentWebSockets   prometheus.Gauge
+	Closes              *prometheus.CounterVec // transport, code
+	ReapedConnections   *prometheus.CounterVec // transport
+
+	// Messages sent to and received from clients
+	Heartbeats    *prometheus.CounterVec // transport
+	Dispatches    *prometheus.CounterVec // type, transport
+	Acks          *prometheus.CounterVec // command
+	Errors        *prometheus.CounterVec // command, code
+	BytesWritten  *prometheus.CounterVec // transport
+	Subscriptions *prometheus.GaugeVec   // object
+
+	// Dispatch latency
+	BrokerLatency prometheus.Histogram     // from being published to being received by the pod
+	QueueLatency  *prometheus.HistogramVec // transport, time spent waiting for the connection to read it
+	WriteLatency  *prometheus.HistogramVec // transport, time taken to write it to the client
+
+	// Message broker
+	BrokerMessages    prometheus.Counter
+	BrokerDropped     *prometheus.CounterVec // reason
+	BrokerConnected   prometheus.Gauge
+	BrokerDisconnects prometheus.Counter
+	BrokerReconnects  prometheus.Counter
+	BrokerErrors      prometheus.Counter
 }
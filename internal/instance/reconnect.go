@@ -0,0 +1,10 @@
+package instance
+
+import "context"
+
+// Reconnects paces the connections told to reconnect across the pod,
+// so that clients don't come back to the load balancer all at once
+type Reconnects interface {
+	// Wait blocks until a connection may be told to reconnect, or the context is done
+	Wait(ctx context.Context) error
+}
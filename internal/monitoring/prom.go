@@ -18,13 +18,28 @@ func (m *mon) EventV3() instance.EventV3 {
 func (m *mon) Register(r prometheus.Registerer) {
 	r.MustRegister(
 		// v3
-		m.eventv3.TotalConnections,
-		m.eventv3.TotalConnectionDurationSeconds,
+		m.eventv3.Connections,
+		m.eventv3.ConnectionDuration,
 		m.eventv3.CurrentConnections,
 		m.eventv3.CurrentEventStreams,
 		m.eventv3.CurrentWebSockets,
+		m.eventv3.Closes,
+		m.eventv3.ReapedConnections,
 		m.eventv3.Heartbeats,
 		m.eventv3.Dispatches,
+		m.eventv3.Acks,
+		m.eventv3.Errors,
+		m.eventv3.BytesWritten,
+		m.eventv3.Subscriptions,
+		m.eventv3.BrokerLatency,
+		m.eventv3.QueueLatency,
+		m.eventv3.WriteLatency,
+		m.eventv3.BrokerMessages,
+		m.eventv3.BrokerDropped,
+		m.eventv3.BrokerConnected,
+		m.eventv3.BrokerDisconnects,
+		m.eventv3.BrokerReconnects,
+		m.eventv3.BrokerErrors,
 	)
 }
 
@@ -39,42 +54,123 @@ func labelsFromKeyValue(kv []configure.KeyValue) prometheus.Labels {
 }
 
 func NewPrometheus(gCtx global.Context) instance.Monitoring {
+	labels := labelsFromKeyValue(gCtx.Config().Monitoring.Labels)
+
 	return &mon{
 		eventv3: instance.EventV3{
-			TotalConnections: prometheus.NewHistogram(prometheus.HistogramOpts{
-				Name:        "events_v3_total_connections",
-				ConstLabels: labelsFromKeyValue(gCtx.Config().Monitoring.Labels),
-				Help:        "The total number of connections",
-			}),
-			TotalConnectionDurationSeconds: prometheus.NewHistogram(prometheus.HistogramOpts{
-				Name:        "events_v3_total_connection_duration_seconds",
-				ConstLabels: labelsFromKeyValue(gCtx.Config().Monitoring.Labels),
-				Help:        "The total number of seconds used on connections",
-			}),
+			Connections: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name:        "events_v3_connections_total",
+				ConstLabels: labels,
+				Help:        "The number of connections accepted",
+			}, []string{"transport"}),
+			ConnectionDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:        "events_v3_connection_duration_seconds",
+				ConstLabels: labels,
+				Help:        "The time connections stayed open for",
+				Buckets:     []float64{1, 10, 60, 300, 900, 1800, 3600, 7200, 14400, 28800, 86400},
+			}, []string{"transport"}),
 			CurrentConnections: prometheus.NewGauge(prometheus.GaugeOpts{
 				Name:        "events_v3_current_connections",
-				ConstLabels: labelsFromKeyValue(gCtx.Config().Monitoring.Labels),
+				ConstLabels: labels,
 				Help:        "The current number of connections",
 			}),
 			CurrentEventStreams: prometheus.NewGauge(prometheus.GaugeOpts{
 				Name:        "events_v3_current_event_streams",
-				ConstLabels: labelsFromKeyValue(gCtx.Config().Monitoring.Labels),
+				ConstLabels: labels,
 				Help:        "The current number of connections via EventStream transport",
 			}),
 			CurrentWebSockets: prometheus.NewGauge(prometheus.GaugeOpts{
 				Name:        "events_v3_current_event_websockets",
-				ConstLabels: labelsFromKeyValue(gCtx.Config().Monitoring.Labels),
+				ConstLabels: labels,
 				Help:        "The current number of connections via WebSocket transport",
 			}),
-			Heartbeats: prometheus.NewHistogram(prometheus.HistogramOpts{
-				Name:        "events_v3_heartbeats",
-				ConstLabels: labelsFromKeyValue(gCtx.Config().Monitoring.Labels),
+			Closes: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name:        "events_v3_closes_total",
+				ConstLabels: labels,
+				Help:        "The number of connections closed by the server, by close code",
+			}, []string{"transport", "code"}),
+			ReapedConnections: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name:        "events_v3_reaped_connections_total",
+				ConstLabels: labels,
+				Help:        "The number of connections closed because the client stopped responding",
+			}, []string{"transport"}),
+			Heartbeats: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name:        "events_v3_heartbeats_total",
+				ConstLabels: labels,
 				Help:        "The number of heartbeats sent out to clients",
-			}),
-			Dispatches: prometheus.NewHistogram(prometheus.HistogramOpts{
-				Name:        "events_v3_dispatches",
-				ConstLabels: labelsFromKeyValue(gCtx.Config().Monitoring.Labels),
+			}, []string{"transport"}),
+			Dispatches: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name:        "events_v3_dispatches_total",
+				ConstLabels: labels,
 				Help:        "The number of dispatches sent out to clients",
+			}, []string{"type", "transport"}),
+			Acks: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name:        "events_v3_acks_total",
+				ConstLabels: labels,
+				Help:        "The number of commands acknowledged",
+			}, []string{"command"}),
+			Errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name:        "events_v3_errors_total",
+				ConstLabels: labels,
+				Help:        "The number of errors sent out to clients, by the command that caused them",
+			}, []string{"command", "code"}),
+			BytesWritten: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name:        "events_v3_written_bytes_total",
+				ConstLabels: labels,
+				Help:        "The number of bytes written to clients",
+			}, []string{"transport"}),
+			Subscriptions: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name:        "events_v3_subscriptions",
+				ConstLabels: labels,
+				Help:        "The current number of subscriptions, by the object of their event type",
+			}, []string{"object"}),
+			BrokerLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+				Name:        "events_v3_broker_latency_seconds",
+				ConstLabels: labels,
+				Help:        "The time between a dispatch being published and it being received by the pod",
+				Buckets:     prometheus.ExponentialBuckets(0.001, 2, 14),
+			}),
+			QueueLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:        "events_v3_queue_latency_seconds",
+				ConstLabels: labels,
+				Help:        "The time a dispatch waited to be read by a connection",
+				Buckets:     prometheus.ExponentialBuckets(0.0001, 2, 14),
+			}, []string{"transport"}),
+			WriteLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:        "events_v3_write_latency_seconds",
+				ConstLabels: labels,
+				Help:        "The time taken to write a dispatch to a client",
+				Buckets:     prometheus.ExponentialBuckets(0.0001, 2, 14),
+			}, []string{"transport"}),
+			BrokerMessages: prometheus.NewCounter(prometheus.CounterOpts{
+				Name:        "events_v3_broker_messages_total",
+				ConstLabels: labels,
+				Help:        "The number of messages received from the message broker",
+			}),
+			BrokerDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name:        "events_v3_broker_dropped_total",
+				ConstLabels: labels,
+				Help:        "The number of messages from the message broker that were dropped before reaching a connection",
+			}, []string{"reason"}),
+			BrokerConnected: prometheus.NewGauge(prometheus.GaugeOpts{
+				Name:        "events_v3_broker_connected",
+				ConstLabels: labels,
+				Help:        "Whether the connection to the message broker is up",
+			}),
+			BrokerDisconnects: prometheus.NewCounter(prometheus.CounterOpts{
+				Name:        "events_v3_broker_disconnects_total",
+				ConstLabels: labels,
+				Help:        "The number of times the connection to the message broker was lost",
+			}),
+			BrokerReconnects: prometheus.NewCounter(prometheus.CounterOpts{
+				Name:        "events_v3_broker_reconnects_total",
+				ConstLabels: labels,
+				Help:        "The number of times the connection to the message broker was re-established",
+			}),
+			BrokerErrors: prometheus.NewCounter(prometheus.CounterOpts{
+				Name:        "events_v3_broker_errors_total",
+				ConstLabels: labels,
+				Help:        "The number of asynchronous errors reported by the message broker",
 			}),
 		},
 	}
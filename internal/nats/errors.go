@@ -2,6 +2,4 @@ package nats
 
 import "errors"
 
-var (
-	ErrSubNotFound = errors.New("subscription not found")
-)
+var ErrReplayLimit = errors.New("too many messages to replay")
@@ -0,0 +1,173 @@
+package nats
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/seventv/common/utils"
+	"go.uber.org/zap"
+
+	"github.com/seventv/eventapi/internal/instance"
+)
+
+// JetStream is a broker consuming the events stream, which allows
+// sessions to replay the dispatches they missed
+type JetStream struct {
+	*Broker
+}
+
+// setup consumes the events stream with an ordered consumer,
+// starting at the messages published from now on
+func (b *JetStream) setup() error {
+	var err error
+
+	b.js, err = b.conn.JetStream()
+	if err != nil {
+		return err
+	}
+
+	if b.stream == "" {
+		if b.stream, err = b.js.StreamNameBySubject(b.wildcard()); err != nil {
+			return err
+		}
+	}
+
+	info, err := b.js.StreamInfo(b.stream)
+	if err != nil {
+		return err
+	}
+
+	atomic.StoreUint64(&b.lastSeq, info.State.LastSeq)
+
+	b.subscription, err = b.js.Subscribe(b.wildcard(), b.handleMessage,
+		nats.BindStream(b.stream),
+		nats.OrderedConsumer(),
+		nats.StartSequence(info.State.LastSeq+1),
+	)
+	if err != nil {
+		return err
+	}
+
+	zap.S().Infow("consuming jetstream",
+		"stream", b.stream,
+		"sequence", info.State.LastSeq,
+	)
+
+	return nil
+}
+
+// LastSequence implements instance.ReplayableBroker
+func (b *JetStream) LastSequence() uint64 {
+	return atomic.LoadUint64(&b.lastSeq)
+}
+
+// Replay implements instance.ReplayableBroker
+//
+// The stream is read with a consumer filtered on each key, so only the wanted messages are walked through
+func (b *JetStream) Replay(ctx context.Context, after uint64, keys ...string) ([]*instance.BrokerMessage, error) {
+	last := b.LastSequence()
+	if after >= last || len(keys) == 0 {
+		return nil, nil
+	}
+
+	if last-after > b.replayLimit {
+		return nil, ErrReplayLimit
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(b.replayTimeout)*time.Millisecond)
+	defer cancel()
+
+	result := []*instance.BrokerMessage{}
+
+	// a message matching both a key and a wildcard is replayed once
+	seen := map[uint64]struct{}{}
+	replayed := make(utils.Set[string], len(keys))
+
+	for _, key := range keys {
+		if replayed.Has(key) {
+			continue
+		}
+
+		replayed.Add(key)
+
+		messages, err := b.replayKey(ctx, after, last, key)
+		for _, msg := range messages {
+			if _, ok := seen[msg.Sequence]; !ok {
+				seen[msg.Sequence] = struct{}{}
+				result = append(result, msg)
+			}
+		}
+
+		if err != nil {
+			return result, err
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Sequence < result[j].Sequence
+	})
+
+	return result, nil
+}
+
+// replayKey retrieves the messages of a key published after a stream sequence, up to the last one consumed
+func (b *JetStream) replayKey(ctx context.Context, after uint64, last uint64, key string) ([]*instance.BrokerMessage, error) {
+	sub, err := b.js.SubscribeSync(b.baseSubject+"."+key,
+		nats.BindStream(b.stream),
+		nats.OrderedConsumer(),
+		nats.StartSequence(after+1),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = sub.Unsubscribe()
+	}()
+
+	info, err := sub.ConsumerInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*instance.BrokerMessage{}
+
+	// the messages up to last may have been removed by the stream's limits,
+	// so the replay ends once none are pending rather than at last
+	for pending := info.NumPending; pending > 0; {
+		msg, err := sub.NextMsgWithContext(ctx)
+		if err != nil {
+			return result, err
+		}
+
+		md, err := msg.Metadata()
+		if err != nil {
+			return result, err
+		}
+
+		// the messages after last are received by the live consumer
+		if md.Sequence.Stream > last {
+			break
+		}
+
+		pending = md.NumPending
+
+		// a wildcard matches the keys one level below it only
+		k := strings.TrimPrefix(msg.Subject, b.baseSubject+".")
+		if strings.HasSuffix(key, instance.KeyWildcard) && k[:strings.LastIndexByte(k, '.')] != strings.TrimSuffix(key, instance.KeyWildcard) {
+			continue
+		}
+
+		result = append(result, &instance.BrokerMessage{
+			Key:      k,
+			Data:     msg.Data,
+			Sequence: md.Sequence.Stream,
+		})
+	}
+
+	return result, nil
+}
@@ -2,21 +2,63 @@ package nats
 
 import (
 	"strings"
+	"sync/atomic"
 
 	"github.com/nats-io/nats.go"
 	"go.uber.org/zap"
+
+	"github.com/seventv/eventapi/internal/instance"
+	"github.com/seventv/eventapi/internal/tracing"
 )
 
-func handleMessage(msg *nats.Msg) {
-	subject := strings.TrimPrefix(msg.Subject, baseSubject+".")
-	mx.Lock()
-	defer mx.Unlock()
-	subs := subjects[subject]
-	for _, sub := range subs {
-		select {
-		case sub.Ch <- msg.Data:
-		default:
-			zap.S().Debug("channel blocked dropping message: ", msg.Subject)
+// headerCarrier reads and writes the trace context in the headers of a NATS message,
+// whose keys are case-sensitive unlike HTTP's
+type headerCarrier nats.Header
+
+func (c headerCarrier) Get(key string) string {
+	for k, v := range c {
+		if strings.EqualFold(k, key) && len(v) > 0 {
+			return v[0]
 		}
 	}
+
+	return ""
+}
+
+func (c headerCarrier) Set(key string, value string) {
+	nats.Header(c).Set(key, value)
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+func (b *Broker) handleMessage(msg *nats.Msg) {
+	m := &instance.BrokerMessage{
+		Key:  strings.TrimPrefix(msg.Subject, b.baseSubject+"."),
+		Data: msg.Data,
+	}
+
+	if msg.Header != nil {
+		m.PublishedAt = instance.ParsePublishedAt(msg.Header.Get(instance.PublishedAtHeader))
+		m.SpanContext = tracing.Extract(headerCarrier(msg.Header))
+	}
+
+	if b.js != nil {
+		md, err := msg.Metadata()
+		if err != nil {
+			zap.S().Errorw("jetstream message metadata", "error", err, "subject", msg.Subject)
+		} else {
+			m.Sequence = md.Sequence.Stream
+
+			atomic.StoreUint64(&b.lastSeq, m.Sequence)
+		}
+	}
+
+	b.Dispatch(m)
 }
@@ -6,40 +6,105 @@ import (
 
 	"github.com/nats-io/nats.go"
 	"go.uber.org/zap"
+
+	"github.com/seventv/eventapi/internal/broker"
+	"github.com/seventv/eventapi/internal/global"
+	"github.com/seventv/eventapi/internal/instance"
 )
 
-func Init(url string, subject string) error {
-	mx = &sync.Mutex{}
-	var err error
-	conn, err = nats.Connect(url)
+// Broker receives dispatches published on "<subject>.<dispatch key>" via NATS
+type Broker struct {
+	*broker.Registry
+
+	subscription *nats.Subscription
+	conn         *nats.Conn
+	js           nats.JetStreamContext
+	baseSubject  string
+	stream       string
+
+	replayLimit   uint64
+	replayTimeout int
+
+	// stream sequence of the last processed message
+	lastSeq uint64
+	// messages dropped by NATS as of the last slow consumer error
+	slowDropped int64
+
+	monitoring instance.Monitoring
+	status     instance.BrokerStatus
+	statusMtx  sync.Mutex
+}
+
+// New connects to NATS, returning a replayable broker if JetStream is enabled
+func New(gctx global.Context) (instance.Broker, error) {
+	cfg := gctx.Config().Nats
+
+	b := &Broker{
+		Registry:      broker.NewRegistry(),
+		baseSubject:   cfg.Subject,
+		stream:        cfg.JetStream.Stream,
+		replayLimit:   cfg.JetStream.ReplayLimit,
+		replayTimeout: cfg.JetStream.ReplayTimeout,
+		monitoring:    gctx.Inst().Monitoring,
+	}
+
+	b.Registry.Monitoring = gctx.Inst().Monitoring
+
+	opts, err := b.options(gctx)
+	if err != nil {
+		return nil, err
+	}
+
+	b.conn, err = nats.Connect(cfg.Url, opts...)
 	if err != nil {
-		return err
+		return nil, err
 	}
+
 	// wait for connection to be clear
-	conn.Flush()
+	if err = b.conn.Flush(); err != nil {
+		b.conn.Close()
+
+		return nil, err
+	}
+
+	b.setConnected(true)
+
+	if !cfg.JetStream.Enabled {
+		sub, err := b.conn.Subscribe(b.wildcard(), b.handleMessage)
+		if err != nil {
+			b.conn.Close()
+
+			return nil, err
+		}
+
+		b.subscription = sub
+
+		return b, nil
+	}
+
+	js := &JetStream{Broker: b}
+	if err = js.setup(); err != nil {
+		b.conn.Close()
 
-	subscription, err = conn.Subscribe(fmt.Sprintf("%v.>", subject), handleMessage)
+		return nil, err
+	}
 
-	baseSubject = subject
-	subjects = make(map[string][]*Subscription)
-	sessions = make(map[string][]string)
-	return err
+	return js, nil
 }
 
-func Close() {
-	err := subscription.Unsubscribe()
+// Close implements instance.Broker
+func (b *Broker) Close() {
+	err := b.subscription.Unsubscribe()
 	if err != nil {
 		zap.S().Errorw("closing NATS", "error", err)
 	}
-	err = conn.Flush()
+	err = b.conn.Flush()
 	if err != nil {
 		zap.S().Errorw("closing NATS", "error", err)
 	}
-	conn.Close()
+	b.conn.Close()
 }
 
-var (
-	subscription *nats.Subscription
-	conn         *nats.Conn
-	baseSubject  string
-)
+func (b *Broker) wildcard() string {
+	return fmt.Sprintf("%v.>", b.baseSubject)
+}
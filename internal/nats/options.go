@@ -0,0 +1,74 @@
+package nats
+
+import (
+	"crypto/tls"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/seventv/eventapi/internal/global"
+)
+
+// options builds the connection options from the config
+func (b *Broker) options(gctx global.Context) ([]nats.Option, error) {
+	cfg := gctx.Config().Nats
+
+	name := cfg.Name
+	if name == "" {
+		name = gctx.Config().Pod.Name
+	}
+
+	maxReconnects := cfg.MaxReconnects
+	if maxReconnects <= 0 {
+		maxReconnects = -1
+	}
+
+	opts := []nats.Option{
+		nats.Name(name),
+		nats.MaxReconnects(maxReconnects),
+		nats.ReconnectWait(time.Duration(cfg.ReconnectWait) * time.Millisecond),
+		nats.ReconnectJitter(time.Duration(cfg.ReconnectJitter)*time.Millisecond, time.Duration(cfg.ReconnectJitter)*time.Millisecond),
+		nats.DisconnectErrHandler(b.onDisconnect),
+		nats.ReconnectHandler(b.onReconnect),
+		nats.ClosedHandler(b.onClose),
+		nats.ErrorHandler(b.onError),
+	}
+
+	if cfg.ReconnectBufferSize > 0 {
+		opts = append(opts, nats.ReconnectBufSize(cfg.ReconnectBufferSize))
+	}
+
+	// Authentication
+	switch {
+	case cfg.CredsFile != "":
+		opts = append(opts, nats.UserCredentials(cfg.CredsFile))
+	case cfg.NKeySeedFile != "":
+		opt, err := nats.NkeyOptionFromSeed(cfg.NKeySeedFile)
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, opt)
+	case cfg.Token != "":
+		opts = append(opts, nats.Token(cfg.Token))
+	case cfg.Username != "":
+		opts = append(opts, nats.UserInfo(cfg.Username, cfg.Password))
+	}
+
+	if cfg.TLS.Enabled {
+		opts = append(opts, nats.Secure(&tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			InsecureSkipVerify: cfg.TLS.InsecureSkipVerify,
+		}))
+
+		if cfg.TLS.CAFile != "" {
+			opts = append(opts, nats.RootCAs(cfg.TLS.CAFile))
+		}
+
+		if cfg.TLS.CertFile != "" {
+			opts = append(opts, nats.ClientCert(cfg.TLS.CertFile, cfg.TLS.KeyFile))
+		}
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,105 @@
+package nats
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+
+	"github.com/seventv/eventapi/internal/instance"
+)
+
+// Status implements instance.Broker
+func (b *Broker) Status() instance.BrokerStatus {
+	b.statusMtx.Lock()
+	defer b.statusMtx.Unlock()
+
+	return b.status
+}
+
+func (b *Broker) setConnected(connected bool) {
+	b.statusMtx.Lock()
+	defer b.statusMtx.Unlock()
+
+	if connected {
+		b.status.DownSince = time.Time{}
+	} else if b.status.Connected || b.status.DownSince.IsZero() {
+		b.status.DownSince = time.Now()
+	}
+
+	b.status.Connected = connected
+
+	if b.monitoring != nil {
+		if connected {
+			b.monitoring.EventV3().BrokerConnected.Set(1)
+		} else {
+			b.monitoring.EventV3().BrokerConnected.Set(0)
+		}
+	}
+}
+
+func (b *Broker) setError(err error) {
+	b.statusMtx.Lock()
+	defer b.statusMtx.Unlock()
+
+	b.status.LastError = err.Error()
+}
+
+func (b *Broker) onDisconnect(nc *nats.Conn, err error) {
+	b.setConnected(false)
+
+	if err != nil {
+		b.setError(err)
+	}
+
+	if b.monitoring != nil {
+		b.monitoring.EventV3().BrokerDisconnects.Inc()
+	}
+
+	zap.S().Warnw("nats disconnected", "error", err)
+}
+
+func (b *Broker) onReconnect(nc *nats.Conn) {
+	b.setConnected(true)
+
+	b.statusMtx.Lock()
+	b.status.Reconnects++
+	b.statusMtx.Unlock()
+
+	if b.monitoring != nil {
+		b.monitoring.EventV3().BrokerReconnects.Inc()
+	}
+
+	zap.S().Infow("nats reconnected", "url", nc.ConnectedUrlRedacted())
+}
+
+func (b *Broker) onClose(nc *nats.Conn) {
+	b.setConnected(false)
+
+	if err := nc.LastError(); err != nil {
+		b.setError(err)
+	}
+
+	zap.S().Warnw("nats connection closed", "error", nc.LastError())
+}
+
+func (b *Broker) onError(nc *nats.Conn, sub *nats.Subscription, err error) {
+	b.setError(err)
+
+	if b.monitoring != nil {
+		b.monitoring.EventV3().BrokerErrors.Inc()
+
+		// the messages NATS dropped because they were not processed fast enough
+		if err == nats.ErrSlowConsumer && sub != nil {
+			if dropped, derr := sub.Dropped(); derr == nil {
+				prev := atomic.SwapInt64(&b.slowDropped, int64(dropped))
+				if int64(dropped) > prev {
+					b.monitoring.EventV3().BrokerDropped.WithLabelValues("slow_consumer").Add(float64(int64(dropped) - prev))
+				}
+			}
+		}
+	}
+
+	zap.S().Errorw("nats error", "error", err)
+}
@@ -1,30 +1,26 @@
 package pprof
 
 import (
-	"fmt"
-	"net/http"
-	_ "net/http/pprof"
+	"net/http/pprof"
+
+	"github.com/go-chi/chi/v5"
 
 	"github.com/seventv/eventapi/internal/global"
-	"go.uber.org/zap"
+	"github.com/seventv/eventapi/internal/httpserver"
 )
 
-func New(gCtx global.Context) <-chan struct{} {
-	done := make(chan struct{})
-
-	go func() {
-		if err := http.ListenAndServe(gCtx.Config().PProf.Bind, nil); err != nil {
-			zap.S().Fatalw("pprof failed to listen",
-				"error", err,
-			)
-		}
-	}()
-
-	go func() {
-		<-gCtx.Done()
-		fmt.Println("done")
-		close(done)
-	}()
+// Routes returns the routes of the profiler, which require the admin token
+func Routes(gCtx global.Context) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.Route("/debug/pprof", func(r chi.Router) {
+			r.Use(httpserver.RequireAdminToken(gCtx))
 
-	return done
+			r.HandleFunc("/cmdline", pprof.Cmdline)
+			r.HandleFunc("/profile", pprof.Profile)
+			r.HandleFunc("/symbol", pprof.Symbol)
+			r.HandleFunc("/trace", pprof.Trace)
+			// the index and the named profiles such as /heap
+			r.HandleFunc("/*", pprof.Index)
+		})
+	}
 }
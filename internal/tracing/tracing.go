@@ -0,0 +1,161 @@
+package tracing
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/seventv/common/utils"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"github.com/seventv/eventapi/internal/global"
+	"github.com/seventv/eventapi/internal/instance"
+)
+
+// dispatchSampleRate is the ratio of dispatched messages traced, zero until tracing is set up
+var dispatchSampleRate float64
+
+// New exports spans via OTLP if tracing is enabled. Spans are otherwise dropped by the default no-op provider.
+//
+// The returned function flushes the spans once the global context is done and the specified channels are closed,
+// so that the spans of the connections closed while shutting down are exported too
+func New(gctx global.Context) func(after ...<-chan struct{}) <-chan struct{} {
+	// propagate trace context to and from other services even if spans aren't exported,
+	// so that the traces of the publishers and the bridge stay connected
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	cfg := gctx.Config().Tracing
+	if !cfg.Enabled {
+		return func(after ...<-chan struct{}) <-chan struct{} {
+			done := make(chan struct{})
+			close(done)
+
+			return done
+		}
+	}
+
+	opts := []otlptracehttp.Option{}
+	if cfg.Endpoint != "" {
+		opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+	}
+
+	if cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(gctx, opts...)
+	if err != nil {
+		zap.S().Fatalw("failed to create trace exporter", "error", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(rate(cfg.SampleRate)))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String("eventapi"),
+			semconv.ServiceInstanceIDKey.String(gctx.Config().Pod.Name),
+		)),
+	)
+
+	otel.SetTracerProvider(provider)
+
+	dispatchSampleRate = rate(cfg.DispatchSampleRate)
+
+	return func(after ...<-chan struct{}) <-chan struct{} {
+		done := make(chan struct{})
+
+		go func() {
+			<-gctx.Done()
+
+			for _, ch := range after {
+				<-ch
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+
+			if err := provider.Shutdown(ctx); err != nil {
+				zap.S().Errorw("failed to flush spans", "error", err)
+			}
+
+			close(done)
+		}()
+
+		return done
+	}
+}
+
+// rate returns a configured sample rate, none if it's negative
+func rate(v float64) float64 {
+	return utils.Ternary(v < 0, 0, v)
+}
+
+// Tracer returns the tracer of the EventAPI
+func Tracer() trace.Tracer {
+	return otel.Tracer("github.com/seventv/eventapi")
+}
+
+// SampleDispatch decides whether a dispatch is traced, once per message for all the connections it is written to
+func SampleDispatch(msg *instance.BrokerMessage) bool {
+	if dispatchSampleRate <= 0 {
+		return false
+	}
+
+	return msg.Sampled(func() bool {
+		return rand.Float64() < dispatchSampleRate
+	})
+}
+
+// StartConnection starts the span of a connection, continuing the trace of the request that opened it
+func StartConnection(ctx context.Context, carrier propagation.TextMapCarrier, transport string) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, carrier)
+
+	return Tracer().Start(ctx, "connection",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("eventapi.transport", transport)),
+	)
+}
+
+// StartDispatch starts the span of writing a dispatch to a connection. It continues the trace of the publisher
+// if known, linked to the span of the connection
+func StartDispatch(conn context.Context, published trace.SpanContext, eventType string) (context.Context, trace.Span) {
+	ctx := conn
+	if published.IsValid() {
+		ctx = trace.ContextWithRemoteSpanContext(conn, published)
+	}
+
+	return Tracer().Start(ctx, "dispatch",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(trace.LinkFromContext(conn)),
+		trace.WithAttributes(attribute.String("eventapi.event_type", eventType)),
+	)
+}
+
+// Extract returns the span context carried by the headers of a message, invalid if there is none
+func Extract(carrier propagation.TextMapCarrier) trace.SpanContext {
+	return trace.SpanContextFromContext(otel.GetTextMapPropagator().Extract(context.Background(), carrier))
+}
+
+// Inject adds the span context of ctx to the headers of an outgoing request
+func Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+}
+
+// End ends a span, recording the error it failed with if any
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
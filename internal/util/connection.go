@@ -17,6 +17,9 @@ func SaveConnInContext(ctx context.Context, c net.Conn) context.Context {
 	return context.WithValue(ctx, ConnContextKey, c)
 }
 
+// GetConn returns the connection a request was received on, nil if it wasn't saved
 func GetConn(r *http.Request) net.Conn {
-	return r.Context().Value(ConnContextKey).(net.Conn)
+	c, _ := r.Context().Value(ConnContextKey).(net.Conn)
+
+	return c
 }
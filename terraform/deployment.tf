@@ -114,11 +114,12 @@ resource "kubernetes_deployment" "app" {
           }
 
           lifecycle {
-            // Pre-stop hook is used to send a fallback signal to the container
-            // to gracefully remove all connections ahead of shutdown
+            // Pre-stop hook is used to drain the connections ahead of shutdown,
+            // failing readiness before telling the clients to reconnect elsewhere.
+            // The newest eventapi process is the one wrapped by the panic handler
             pre_stop {
               exec {
-                command = ["sh", "-c", "sleep 5 && echo \"1\" >> shutdown"]
+                command = ["sh", "-c", "pkill -USR1 -n -x eventapi"]
               }
             }
           }
@@ -142,7 +143,7 @@ resource "kubernetes_deployment" "app" {
 
           liveness_probe {
             http_get {
-              path = "/"
+              path = "/livez"
               port = "health"
             }
             initial_delay_seconds = 10
@@ -154,7 +155,7 @@ resource "kubernetes_deployment" "app" {
 
           readiness_probe {
             http_get {
-              path = "/"
+              path = "/readyz"
               port = "health"
             }
             initial_delay_seconds = 3